@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// alert is an alert read from a file, for simulations.
+type alert struct {
+	Labels map[string]string `json:"labels"`
+	// Resolved is true for alerts that were no longer firing
+	Resolved bool `json:"resolved,omitempty"`
+}
+
+// readAlerts reads the alerts in a JSON or YAML file. The file may hold several documents, or
+// one JSON object per line. Each document may be a list of alerts, such as from Alertmanager's
+// /api/v2/alerts, a webhook payload with an alerts list, or a single alert. An alert is either
+// an object with a labels map, or just the labels.
+func readAlerts(file string) ([]*alert, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", file)
+	}
+
+	var alerts []*alert
+	for _, doc := range splitDocuments(data) {
+		var values []interface{}
+		if isJSONLines(doc) {
+			values, err = decodeJSONLines(doc)
+			if err != nil {
+				return nil, errors.Wrap(err, file)
+			}
+		} else {
+			var v interface{}
+			if err := yaml.Unmarshal(doc.Data, &v); err != nil {
+				_, err = documentError(err, doc.Line)
+				return nil, errors.Wrap(err, file)
+			}
+			values = append(values, v)
+		}
+
+		for _, v := range values {
+			a, err := alertsFromDocument(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s:%d", file, doc.Line)
+			}
+			alerts = append(alerts, a...)
+		}
+	}
+	return alerts, nil
+}
+
+// isJSONLines returns true if doc has more than one line and every line is a JSON object.
+// yaml only decodes the first of several objects, so these are decoded a line at a time.
+func isJSONLines(doc *yamlDocument) bool {
+	n := 0
+	for _, line := range strings.Split(string(doc.Data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "{") || !strings.HasSuffix(line, "}") {
+			return false
+		}
+		n++
+	}
+	return n > 1
+}
+
+// decodeJSONLines decodes each non-empty line of doc as a separate value.
+func decodeJSONLines(doc *yamlDocument) ([]interface{}, error) {
+	var values []interface{}
+	for i, line := range strings.Split(string(doc.Data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(line), &v); err != nil {
+			return nil, errors.Wrapf(err, "line %d", doc.Line+i)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// alertsFromDocument returns the alerts in a decoded document.
+func alertsFromDocument(v interface{}) ([]*alert, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var alerts []*alert
+		for _, item := range v {
+			a, err := alertFromValue(item)
+			if err != nil {
+				return nil, err
+			}
+			alerts = append(alerts, a)
+		}
+		return alerts, nil
+	case map[interface{}]interface{}:
+		if list, ok := v["alerts"]; ok {
+			return alertsFromDocument(list)
+		}
+		a, err := alertFromValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return []*alert{a}, nil
+	}
+	return nil, errors.Errorf("expected a list of alerts or an object, got %T", v)
+}
+
+// alertFromValue returns the alert in a decoded object.
+func alertFromValue(v interface{}) (*alert, error) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.Errorf("expected an alert object, got %T", v)
+	}
+
+	a := &alert{}
+	labels, ok := m["labels"]
+	if !ok {
+		// the object is just the labels
+		a.Labels, ok = stringMap(m)
+		if !ok {
+			return nil, errors.New("alert labels must be a map of strings")
+		}
+		return a, nil
+	}
+	a.Labels, ok = stringMap(labels)
+	if !ok {
+		return nil, errors.New("alert labels must be a map of strings")
+	}
+
+	// webhook payloads use a string status, the API an object with a state
+	switch status := m["status"].(type) {
+	case string:
+		a.Resolved = status == "resolved"
+	case map[interface{}]interface{}:
+		a.Resolved = status["state"] == "resolved"
+	}
+	return a, nil
+}
+
+// stringMap converts a decoded YAML map to a map of strings. Scalar values are formatted as strings.
+func stringMap(v interface{}) (map[string]string, bool) {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, false
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		switch v.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			return nil, false
+		case nil:
+			out[fmt.Sprint(k)] = ""
+		default:
+			out[fmt.Sprint(k)] = fmt.Sprint(v)
+		}
+	}
+	return out, true
+}
+
+// formatLabels formats labels like {alertname="Foo", severity="critical"}.
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -1,5 +1,16 @@
 package main
 
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
 // from prometheus alertmanager config package
 
 // Config is the top-level configuration for Alertmanager's config files.
@@ -9,6 +20,9 @@ type Config struct {
 	InhibitRules []*InhibitRule `yaml:"inhibit_rules,omitempty" json:"inhibit_rules,omitempty"`
 	Receivers    []*Receiver    `yaml:"receivers,omitempty" json:"receivers,omitempty"`
 	Templates    []string       `yaml:"templates" json:"templates"`
+	// MuteTimeIntervals is the older name for TimeIntervals. It is only read from existing configs.
+	MuteTimeIntervals []*TimeInterval `yaml:"mute_time_intervals,omitempty" json:"mute_time_intervals,omitempty"`
+	TimeIntervals     []*TimeInterval `yaml:"time_intervals,omitempty" json:"time_intervals,omitempty"`
 }
 
 // GlobalConfig defines configuration parameters that are valid globally
@@ -29,8 +43,12 @@ type GlobalConfig struct {
 	PagerdutyURL     string `yaml:"pagerduty_url,omitempty" json:"pagerduty_url"`
 	HipchatURL       string `yaml:"hipchat_url,omitempty" json:"hipchat_url"`
 	HipchatAuthToken string `yaml:"hipchat_auth_token,omitempty" json:"hipchat_auth_token"`
+	OpsGenieAPIKey   string `yaml:"opsgenie_api_key,omitempty" json:"opsgenie_api_key"`
 	OpsGenieAPIHost  string `yaml:"opsgenie_api_host,omitempty" json:"opsgenie_api_host"`
+	VictorOpsAPIKey  string `yaml:"victorops_api_key,omitempty" json:"victorops_api_key"`
 	VictorOpsAPIURL  string `yaml:"victorops_api_url,omitempty" json:"victorops_api_url"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 // A Route is a node that contains definitions of how to handle alerts.
@@ -40,12 +58,50 @@ type Route struct {
 
 	Match    map[string]string `yaml:"match,omitempty" json:"match,omitempty"`
 	MatchRE  map[string]string `yaml:"match_re,omitempty" json:"match_re,omitempty"`
+	Matchers []string          `yaml:"matchers,omitempty" json:"matchers,omitempty"`
 	Continue bool              `yaml:"continue,omitempty" json:"continue,omitempty"`
 	Routes   []*Route          `yaml:"routes,omitempty" json:"routes,omitempty"`
 
 	GroupWait      *string `yaml:"group_wait,omitempty" json:"group_wait,omitempty"`
 	GroupInterval  *string `yaml:"group_interval,omitempty" json:"group_interval,omitempty"`
 	RepeatInterval *string `yaml:"repeat_interval,omitempty" json:"repeat_interval,omitempty"`
+
+	MuteTimeIntervals   []string `yaml:"mute_time_intervals,omitempty" json:"mute_time_intervals,omitempty"`
+	ActiveTimeIntervals []string `yaml:"active_time_intervals,omitempty" json:"active_time_intervals,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+
+	// configmap the route was defined in, if known. it is not part of the config.
+	source *ConfigMap
+}
+
+// TimeInterval is a named set of periods that routes can be muted or active in.
+type TimeInterval struct {
+	Name          string        `yaml:"name" json:"name"`
+	TimeIntervals []*TimePeriod `yaml:"time_intervals,omitempty" json:"time_intervals,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// TimePeriod is a period of time such as weekday business hours. The values are
+// checked by Alertmanager when it loads the config.
+type TimePeriod struct {
+	Times       []*TimeRange `yaml:"times,omitempty" json:"times,omitempty"`
+	Weekdays    []string     `yaml:"weekdays,omitempty" json:"weekdays,omitempty"`
+	DaysOfMonth []string     `yaml:"days_of_month,omitempty" json:"days_of_month,omitempty"`
+	Months      []string     `yaml:"months,omitempty" json:"months,omitempty"`
+	Years       []string     `yaml:"years,omitempty" json:"years,omitempty"`
+	Location    string       `yaml:"location,omitempty" json:"location,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// TimeRange is a range of time within a day.
+type TimeRange struct {
+	StartTime string `yaml:"start_time" json:"start_time"`
+	EndTime   string `yaml:"end_time" json:"end_time"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 // InhibitRule defines an inhibition rule that mutes alerts that match the
@@ -64,9 +120,18 @@ type InhibitRule struct {
 	// TargetMatchRE defines pairs like TargetMatch but does regular expression
 	// matching.
 	TargetMatchRE map[string]string `yaml:"target_match_re,omitempty" json:"target_match_re"`
+	// SourceMatchers and TargetMatchers are lists of matchers, such as
+	// severity="critical", that source and target alerts must satisfy.
+	SourceMatchers []string `yaml:"source_matchers,omitempty" json:"source_matchers,omitempty"`
+	TargetMatchers []string `yaml:"target_matchers,omitempty" json:"target_matchers,omitempty"`
 	// A set of labels that must be equal between the source and target alert
 	// for them to be a match.
-	Equal string `yaml:"equal,omitempty" json:"equal,omitempty"`
+	Equal []string `yaml:"equal,omitempty" json:"equal,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+
+	// configmap the rule was defined in, if known. it is not part of the config.
+	source *ConfigMap
 }
 
 // Receiver configuration provides configuration on how to contact a receiver.
@@ -74,19 +139,22 @@ type Receiver struct {
 	// A unique identifier for this receiver.
 	Name string `yaml:"name" json:"name"`
 
-	EmailConfigs []*EmailConfig `yaml:"email_configs,omitempty" json:"email_configs,omitempty"`
-	//PagerdutyConfigs []*PagerdutyConfig `yaml:"pagerduty_configs,omitempty" json:"pagerduty_configs,omitempty"`
-	//HipchatConfigs   []*HipchatConfig   `yaml:"hipchat_configs,omitempty" json:"hipchat_configs,omitempty"`
-	//SlackConfigs     []*SlackConfig     `yaml:"slack_configs,omitempty" json:"slack_configs,omitempty"`
-	WebhookConfigs []*WebhookConfig `yaml:"webhook_configs,omitempty" json:"webhook_configs,omitempty"`
-	//OpsGenieConfigs  []*OpsGenieConfig  `yaml:"opsgenie_configs,omitempty" json:"opsgenie_configs,omitempty"`
-	//PushoverConfigs  []*PushoverConfig  `yaml:"pushover_configs,omitempty" json:"pushover_configs,omitempty"`
-	//VictorOpsConfigs []*VictorOpsConfig `yaml:"victorops_configs,omitempty" json:"victorops_configs,omitempty"`
+	EmailConfigs     []*EmailConfig     `yaml:"email_configs,omitempty" json:"email_configs,omitempty"`
+	PagerdutyConfigs []*PagerdutyConfig `yaml:"pagerduty_configs,omitempty" json:"pagerduty_configs,omitempty"`
+	HipchatConfigs   []*HipchatConfig   `yaml:"hipchat_configs,omitempty" json:"hipchat_configs,omitempty"`
+	SlackConfigs     []*SlackConfig     `yaml:"slack_configs,omitempty" json:"slack_configs,omitempty"`
+	WebhookConfigs   []*WebhookConfig   `yaml:"webhook_configs,omitempty" json:"webhook_configs,omitempty"`
+	OpsGenieConfigs  []*OpsGenieConfig  `yaml:"opsgenie_configs,omitempty" json:"opsgenie_configs,omitempty"`
+	PushoverConfigs  []*PushoverConfig  `yaml:"pushover_configs,omitempty" json:"pushover_configs,omitempty"`
+	VictorOpsConfigs []*VictorOpsConfig `yaml:"victorops_configs,omitempty" json:"victorops_configs,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 // NotifierConfig contains base options common across all notifier configurations.
 type NotifierConfig struct {
-	VSendResolved bool `yaml:"send_resolved" json:"send_resolved"`
+	// VSendResolved is left unset so Alertmanager applies the default for each notifier type.
+	VSendResolved *bool `yaml:"send_resolved,omitempty" json:"send_resolved,omitempty"`
 }
 
 // EmailConfig configures notifications via mail.
@@ -101,15 +169,768 @@ type EmailConfig struct {
 	AuthPassword string            `yaml:"auth_password,omitempty" json:"auth_password"`
 	AuthSecret   string            `yaml:"auth_secret,omitempty" json:"auth_secret"`
 	AuthIdentity string            `yaml:"auth_identity,omitempty" json:"auth_identity"`
+	Hello        string            `yaml:"hello,omitempty" json:"hello,omitempty"`
 	Headers      map[string]string `yaml:"headers,omitempty" json:"headers"`
 	HTML         string            `yaml:"html,omitempty" json:"html"`
+	Text         string            `yaml:"text,omitempty" json:"text,omitempty"`
 	RequireTLS   *bool             `yaml:"require_tls,omitempty" json:"require_tls,omitempty"`
+	TLSConfig    *TLSConfig        `yaml:"tls_config,omitempty" json:"tls_config,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 // WebhookConfig configures notifications via a generic webhook.
 type WebhookConfig struct {
 	NotifierConfig `yaml:",inline" json:",inline"`
 
+	HTTPConfig *HTTPClientConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+
 	// URL to send POST request to.
 	URL string `yaml:"url" json:"url"`
+	// MaxAlerts is the most alerts sent in one message. 0 means all of them.
+	MaxAlerts uint64 `yaml:"max_alerts,omitempty" json:"max_alerts,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// PagerdutyConfig configures notifications via PagerDuty.
+type PagerdutyConfig struct {
+	NotifierConfig `yaml:",inline" json:",inline"`
+
+	HTTPConfig *HTTPClientConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+
+	ServiceKey  string            `yaml:"service_key,omitempty" json:"service_key,omitempty"`
+	RoutingKey  string            `yaml:"routing_key,omitempty" json:"routing_key,omitempty"`
+	URL         string            `yaml:"url,omitempty" json:"url,omitempty"`
+	Client      string            `yaml:"client,omitempty" json:"client,omitempty"`
+	ClientURL   string            `yaml:"client_url,omitempty" json:"client_url,omitempty"`
+	Description string            `yaml:"description,omitempty" json:"description,omitempty"`
+	Details     map[string]string `yaml:"details,omitempty" json:"details,omitempty"`
+	Images      []*PagerdutyImage `yaml:"images,omitempty" json:"images,omitempty"`
+	Links       []*PagerdutyLink  `yaml:"links,omitempty" json:"links,omitempty"`
+	Severity    string            `yaml:"severity,omitempty" json:"severity,omitempty"`
+	Class       string            `yaml:"class,omitempty" json:"class,omitempty"`
+	Component   string            `yaml:"component,omitempty" json:"component,omitempty"`
+	Group       string            `yaml:"group,omitempty" json:"group,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// PagerdutyImage is an image attached to a PagerDuty incident.
+type PagerdutyImage struct {
+	Src  string `yaml:"src,omitempty" json:"src,omitempty"`
+	Alt  string `yaml:"alt,omitempty" json:"alt,omitempty"`
+	Href string `yaml:"href,omitempty" json:"href,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// PagerdutyLink is a link attached to a PagerDuty incident.
+type PagerdutyLink struct {
+	Href string `yaml:"href,omitempty" json:"href,omitempty"`
+	Text string `yaml:"text,omitempty" json:"text,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// SlackConfig configures notifications via Slack.
+type SlackConfig struct {
+	NotifierConfig `yaml:",inline" json:",inline"`
+
+	HTTPConfig *HTTPClientConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+
+	APIURL string `yaml:"api_url,omitempty" json:"api_url,omitempty"`
+
+	// Slack channel override, (like #other-channel or @username).
+	Channel  string `yaml:"channel,omitempty" json:"channel,omitempty"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Color    string `yaml:"color,omitempty" json:"color,omitempty"`
+
+	Title       string         `yaml:"title,omitempty" json:"title,omitempty"`
+	TitleLink   string         `yaml:"title_link,omitempty" json:"title_link,omitempty"`
+	Pretext     string         `yaml:"pretext,omitempty" json:"pretext,omitempty"`
+	Text        string         `yaml:"text,omitempty" json:"text,omitempty"`
+	Fields      []*SlackField  `yaml:"fields,omitempty" json:"fields,omitempty"`
+	ShortFields bool           `yaml:"short_fields,omitempty" json:"short_fields,omitempty"`
+	Footer      string         `yaml:"footer,omitempty" json:"footer,omitempty"`
+	Fallback    string         `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+	CallbackID  string         `yaml:"callback_id,omitempty" json:"callback_id,omitempty"`
+	IconEmoji   string         `yaml:"icon_emoji,omitempty" json:"icon_emoji,omitempty"`
+	IconURL     string         `yaml:"icon_url,omitempty" json:"icon_url,omitempty"`
+	ImageURL    string         `yaml:"image_url,omitempty" json:"image_url,omitempty"`
+	ThumbURL    string         `yaml:"thumb_url,omitempty" json:"thumb_url,omitempty"`
+	LinkNames   bool           `yaml:"link_names,omitempty" json:"link_names,omitempty"`
+	MrkdwnIn    []string       `yaml:"mrkdwn_in,omitempty" json:"mrkdwn_in,omitempty"`
+	Actions     []*SlackAction `yaml:"actions,omitempty" json:"actions,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// SlackField is a field attached to a Slack message.
+type SlackField struct {
+	Title string `yaml:"title,omitempty" json:"title,omitempty"`
+	Value string `yaml:"value,omitempty" json:"value,omitempty"`
+	Short *bool  `yaml:"short,omitempty" json:"short,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// SlackAction is a button attached to a Slack message.
+type SlackAction struct {
+	Type         string                  `yaml:"type,omitempty" json:"type,omitempty"`
+	Text         string                  `yaml:"text,omitempty" json:"text,omitempty"`
+	URL          string                  `yaml:"url,omitempty" json:"url,omitempty"`
+	Style        string                  `yaml:"style,omitempty" json:"style,omitempty"`
+	Name         string                  `yaml:"name,omitempty" json:"name,omitempty"`
+	Value        string                  `yaml:"value,omitempty" json:"value,omitempty"`
+	ConfirmField *SlackConfirmationField `yaml:"confirm,omitempty" json:"confirm,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// SlackConfirmationField asks for confirmation before a Slack action is taken.
+type SlackConfirmationField struct {
+	Text        string `yaml:"text,omitempty" json:"text,omitempty"`
+	Title       string `yaml:"title,omitempty" json:"title,omitempty"`
+	OkText      string `yaml:"ok_text,omitempty" json:"ok_text,omitempty"`
+	DismissText string `yaml:"dismiss_text,omitempty" json:"dismiss_text,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// HipchatConfig configures notifications via Hipchat.
+type HipchatConfig struct {
+	NotifierConfig `yaml:",inline" json:",inline"`
+
+	HTTPConfig *HTTPClientConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+
+	APIURL        string `yaml:"api_url,omitempty" json:"api_url,omitempty"`
+	AuthToken     string `yaml:"auth_token,omitempty" json:"auth_token,omitempty"`
+	RoomID        string `yaml:"room_id" json:"room_id"`
+	From          string `yaml:"from,omitempty" json:"from,omitempty"`
+	Notify        bool   `yaml:"notify,omitempty" json:"notify,omitempty"`
+	Message       string `yaml:"message,omitempty" json:"message,omitempty"`
+	MessageFormat string `yaml:"message_format,omitempty" json:"message_format,omitempty"`
+	Color         string `yaml:"color,omitempty" json:"color,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// OpsGenieConfig configures notifications via OpsGenie.
+type OpsGenieConfig struct {
+	NotifierConfig `yaml:",inline" json:",inline"`
+
+	HTTPConfig *HTTPClientConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+
+	APIKey      string               `yaml:"api_key,omitempty" json:"api_key,omitempty"`
+	APIURL      string               `yaml:"api_url,omitempty" json:"api_url,omitempty"`
+	Message     string               `yaml:"message,omitempty" json:"message,omitempty"`
+	Description string               `yaml:"description,omitempty" json:"description,omitempty"`
+	Source      string               `yaml:"source,omitempty" json:"source,omitempty"`
+	Details     map[string]string    `yaml:"details,omitempty" json:"details,omitempty"`
+	Responders  []*OpsGenieResponder `yaml:"responders,omitempty" json:"responders,omitempty"`
+	Teams       string               `yaml:"teams,omitempty" json:"teams,omitempty"`
+	Tags        string               `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Note        string               `yaml:"note,omitempty" json:"note,omitempty"`
+	Priority    string               `yaml:"priority,omitempty" json:"priority,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// OpsGenieResponder is a team, user, escalation, or schedule an OpsGenie alert is assigned to.
+type OpsGenieResponder struct {
+	// one of ID, Name, or Username identifies the responder
+	ID       string `yaml:"id,omitempty" json:"id,omitempty"`
+	Name     string `yaml:"name,omitempty" json:"name,omitempty"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Type     string `yaml:"type,omitempty" json:"type,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// PushoverConfig configures notifications via Pushover.
+type PushoverConfig struct {
+	NotifierConfig `yaml:",inline" json:",inline"`
+
+	HTTPConfig *HTTPClientConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+
+	UserKey  string `yaml:"user_key,omitempty" json:"user_key,omitempty"`
+	Token    string `yaml:"token,omitempty" json:"token,omitempty"`
+	Title    string `yaml:"title,omitempty" json:"title,omitempty"`
+	Message  string `yaml:"message,omitempty" json:"message,omitempty"`
+	URL      string `yaml:"url,omitempty" json:"url,omitempty"`
+	URLTitle string `yaml:"url_title,omitempty" json:"url_title,omitempty"`
+	Sound    string `yaml:"sound,omitempty" json:"sound,omitempty"`
+	Priority string `yaml:"priority,omitempty" json:"priority,omitempty"`
+	Retry    string `yaml:"retry,omitempty" json:"retry,omitempty"`
+	Expire   string `yaml:"expire,omitempty" json:"expire,omitempty"`
+	HTML     bool   `yaml:"html,omitempty" json:"html,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// VictorOpsConfig configures notifications via VictorOps.
+type VictorOpsConfig struct {
+	NotifierConfig `yaml:",inline" json:",inline"`
+
+	HTTPConfig *HTTPClientConfig `yaml:"http_config,omitempty" json:"http_config,omitempty"`
+
+	APIKey            string            `yaml:"api_key,omitempty" json:"api_key,omitempty"`
+	APIURL            string            `yaml:"api_url,omitempty" json:"api_url,omitempty"`
+	RoutingKey        string            `yaml:"routing_key" json:"routing_key"`
+	MessageType       string            `yaml:"message_type,omitempty" json:"message_type,omitempty"`
+	StateMessage      string            `yaml:"state_message,omitempty" json:"state_message,omitempty"`
+	EntityDisplayName string            `yaml:"entity_display_name,omitempty" json:"entity_display_name,omitempty"`
+	MonitoringTool    string            `yaml:"monitoring_tool,omitempty" json:"monitoring_tool,omitempty"`
+	CustomFields      map[string]string `yaml:"custom_fields,omitempty" json:"custom_fields,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// from prometheus common config package
+
+// HTTPClientConfig configures an HTTP client used by a notifier.
+type HTTPClientConfig struct {
+	// The HTTP basic authentication credentials for the targets.
+	BasicAuth *BasicAuth `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
+	// The bearer token for the targets.
+	BearerToken string `yaml:"bearer_token,omitempty" json:"bearer_token,omitempty"`
+	// The bearer token file for the targets.
+	BearerTokenFile string `yaml:"bearer_token_file,omitempty" json:"bearer_token_file,omitempty"`
+	// HTTP proxy server to use to connect to the targets.
+	ProxyURL string `yaml:"proxy_url,omitempty" json:"proxy_url,omitempty"`
+	// TLSConfig to use to connect to the targets.
+	TLSConfig *TLSConfig `yaml:"tls_config,omitempty" json:"tls_config,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// BasicAuth contains basic HTTP authentication credentials.
+type BasicAuth struct {
+	Username     string `yaml:"username" json:"username"`
+	Password     string `yaml:"password,omitempty" json:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// TLSConfig configures the options for TLS connections.
+type TLSConfig struct {
+	// The CA cert to use for the targets.
+	CAFile string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`
+	// The client cert file for the targets.
+	CertFile string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	// The client key file for the targets.
+	KeyFile string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	// Used to verify the hostname for the targets.
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+	// Disable target certificate validation.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+// durationRE matches durations in the format used by Prometheus and Alertmanager, such as 1h30m or 1d.
+var durationRE = regexp.MustCompile("^(([0-9]+)y)?(([0-9]+)w)?(([0-9]+)d)?(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?$")
+
+// parseDuration parses a duration in the format used by Alertmanager.
+func parseDuration(s string) (time.Duration, error) {
+	m := durationRE.FindStringSubmatch(s)
+	if s == "" || m == nil {
+		return 0, fmt.Errorf("not a valid duration string: %q", s)
+	}
+
+	units := []time.Duration{
+		365 * 24 * time.Hour,
+		7 * 24 * time.Hour,
+		24 * time.Hour,
+		time.Hour,
+		time.Minute,
+		time.Second,
+		time.Millisecond,
+	}
+	var d time.Duration
+	for i, u := range units {
+		v := m[2*i+2]
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a valid duration string: %q", s)
+		}
+		d += time.Duration(n) * u
+	}
+	return d, nil
+}
+
+// compileMatchRE compiles a label regular expression anchored the way Alertmanager does.
+func compileMatchRE(re string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + re + ")$")
+}
+
+func validateMatchRE(field string, m map[string]string) error {
+	for k, v := range m {
+		if _, err := compileMatchRE(v); err != nil {
+			return errors.Wrapf(err, "invalid regular expression for %s %s", field, k)
+		}
+	}
+	return nil
+}
+
+func validateURL(field, u string) error {
+	if u == "" {
+		return errors.Errorf("missing %s", field)
+	}
+	if _, err := url.Parse(u); err != nil {
+		return errors.Wrapf(err, "invalid %s", field)
+	}
+	return nil
+}
+
+func (g *GlobalConfig) validate() error {
+	if g.ResolveTimeout != "" {
+		if _, err := parseDuration(g.ResolveTimeout); err != nil {
+			return errors.Wrap(err, "invalid resolve_timeout")
+		}
+	}
+	return nil
+}
+
+func (r *Route) validate() error {
+	for _, d := range []struct {
+		field string
+		value *string
+	}{
+		{"group_wait", r.GroupWait},
+		{"group_interval", r.GroupInterval},
+		{"repeat_interval", r.RepeatInterval},
+	} {
+		if d.value == nil {
+			continue
+		}
+		if _, err := parseDuration(*d.value); err != nil {
+			return errors.Wrapf(err, "invalid %s", d.field)
+		}
+	}
+	if err := validateLabelNames("match", r.Match); err != nil {
+		return err
+	}
+	if err := validateLabelNames("match_re", r.MatchRE); err != nil {
+		return err
+	}
+	if err := validateMatchRE("match_re", r.MatchRE); err != nil {
+		return err
+	}
+	if err := validateMatchers("matchers", r.Matchers); err != nil {
+		return err
+	}
+	for _, child := range r.Routes {
+		if err := child.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSource records cm as the source of r and the routes nested in it.
+func (r *Route) setSource(cm *ConfigMap) {
+	r.source = cm
+	for _, child := range r.Routes {
+		child.setSource(cm)
+	}
+}
+
+// receivers returns the names of the receivers used by r and its children.
+func (r *Route) receivers() []string {
+	var names []string
+	if r.Receiver != "" {
+		names = append(names, r.Receiver)
+	}
+	for _, child := range r.Routes {
+		names = append(names, child.receivers()...)
+	}
+	return names
+}
+
+// timeIntervals returns the names of the time intervals used by r and its children.
+func (r *Route) timeIntervals() []string {
+	names := append(append([]string(nil), r.MuteTimeIntervals...), r.ActiveTimeIntervals...)
+	for _, child := range r.Routes {
+		names = append(names, child.timeIntervals()...)
+	}
+	return names
+}
+
+func (t *TimeInterval) validate() error {
+	if t.Name == "" {
+		return errors.New("missing name on time interval")
+	}
+	return nil
+}
+
+func (r *InhibitRule) validate() error {
+	for field, m := range map[string]map[string]string{
+		"source_match":    r.SourceMatch,
+		"source_match_re": r.SourceMatchRE,
+		"target_match":    r.TargetMatch,
+		"target_match_re": r.TargetMatchRE,
+	} {
+		if err := validateLabelNames(field, m); err != nil {
+			return err
+		}
+	}
+	if err := validateMatchRE("source_match_re", r.SourceMatchRE); err != nil {
+		return err
+	}
+	if err := validateMatchRE("target_match_re", r.TargetMatchRE); err != nil {
+		return err
+	}
+	if err := validateMatchers("source_matchers", r.SourceMatchers); err != nil {
+		return err
+	}
+	if err := validateMatchers("target_matchers", r.TargetMatchers); err != nil {
+		return err
+	}
+	for _, l := range r.Equal {
+		if !labelNameRE.MatchString(l) {
+			return errors.Errorf("invalid label name %q in equal", l)
+		}
+	}
+	return nil
+}
+
+func (r *Receiver) validate() error {
+	if r.Name == "" {
+		return errors.New("missing name on receiver")
+	}
+	for _, c := range r.EmailConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.PagerdutyConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.HipchatConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.SlackConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.WebhookConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.OpsGenieConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.PushoverConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	for _, c := range r.VictorOpsConfigs {
+		if err := c.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *EmailConfig) validate() error {
+	if c.To == "" {
+		return errors.New("missing to address in email config")
+	}
+	return nil
+}
+
+func (c *WebhookConfig) validate() error {
+	if err := validateURL("url in webhook config", c.URL); err != nil {
+		return err
+	}
+	return c.HTTPConfig.validate()
+}
+
+func (c *PagerdutyConfig) validate() error {
+	if c.ServiceKey == "" && c.RoutingKey == "" {
+		return errors.New("missing service or routing key in PagerDuty config")
+	}
+	if c.URL != "" {
+		if err := validateURL("url in PagerDuty config", c.URL); err != nil {
+			return err
+		}
+	}
+	return c.HTTPConfig.validate()
+}
+
+func (c *SlackConfig) validate() error {
+	if c.APIURL != "" {
+		if err := validateURL("api_url in Slack config", c.APIURL); err != nil {
+			return err
+		}
+	}
+	for _, f := range c.Fields {
+		if f.Title == "" {
+			return errors.New("missing title in Slack field")
+		}
+		if f.Value == "" {
+			return errors.New("missing value in Slack field")
+		}
+	}
+	for _, a := range c.Actions {
+		if a.Type == "" {
+			return errors.New("missing type in Slack action")
+		}
+		if a.Text == "" {
+			return errors.New("missing text in Slack action")
+		}
+		if a.URL == "" && a.Name == "" {
+			return errors.New("missing url or name in Slack action")
+		}
+		if a.ConfirmField != nil && a.ConfirmField.Text == "" {
+			return errors.New("missing text in Slack action confirm")
+		}
+	}
+	return c.HTTPConfig.validate()
+}
+
+func (c *HipchatConfig) validate() error {
+	if c.RoomID == "" {
+		return errors.New("missing room id in Hipchat config")
+	}
+	if c.APIURL != "" {
+		if err := validateURL("api_url in Hipchat config", c.APIURL); err != nil {
+			return err
+		}
+	}
+	switch c.MessageFormat {
+	case "", "text", "html":
+	default:
+		return errors.Errorf("invalid message_format %q in Hipchat config, must be text or html", c.MessageFormat)
+	}
+	return c.HTTPConfig.validate()
+}
+
+func (c *OpsGenieConfig) validate() error {
+	if c.APIURL != "" {
+		if err := validateURL("api_url in OpsGenie config", c.APIURL); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.Responders {
+		if r.ID == "" && r.Name == "" && r.Username == "" {
+			return errors.New("OpsGenie responder must have one of id, name, or username")
+		}
+		switch r.Type {
+		case "team", "user", "escalation", "schedule":
+		default:
+			return errors.Errorf("invalid responder type %q in OpsGenie config, must be team, user, escalation, or schedule", r.Type)
+		}
+	}
+	return c.HTTPConfig.validate()
+}
+
+func (c *PushoverConfig) validate() error {
+	if c.UserKey == "" {
+		return errors.New("missing user key in Pushover config")
+	}
+	if c.Token == "" {
+		return errors.New("missing token in Pushover config")
+	}
+	for _, d := range []struct {
+		field string
+		value string
+	}{
+		{"retry", c.Retry},
+		{"expire", c.Expire},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if _, err := parseDuration(d.value); err != nil {
+			return errors.Wrapf(err, "invalid %s in Pushover config", d.field)
+		}
+	}
+	return c.HTTPConfig.validate()
+}
+
+func (c *VictorOpsConfig) validate() error {
+	if c.RoutingKey == "" {
+		return errors.New("missing routing key in VictorOps config")
+	}
+	switch c.MessageType {
+	case "", "CRITICAL", "WARNING", "INFO", "ACKNOWLEDGEMENT", "RECOVERY":
+	default:
+		return errors.Errorf("invalid message_type %q in VictorOps config", c.MessageType)
+	}
+	return c.HTTPConfig.validate()
+}
+
+// validate checks an optional http_config block.
+func (c *HTTPClientConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+	auth := 0
+	if c.BasicAuth != nil {
+		auth++
+	}
+	if c.BearerToken != "" {
+		auth++
+	}
+	if c.BearerTokenFile != "" {
+		auth++
+	}
+	if auth > 1 {
+		return errors.New("at most one of basic_auth, bearer_token & bearer_token_file must be configured in http_config")
+	}
+	if c.BasicAuth != nil && c.BasicAuth.Password != "" && c.BasicAuth.PasswordFile != "" {
+		return errors.New("at most one of basic_auth password & password_file must be configured in http_config")
+	}
+	if c.ProxyURL != "" {
+		if err := validateURL("proxy_url in http_config", c.ProxyURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadConfig parses and validates a rendered config using the same rules
+// Alertmanager applies when loading its config file.
+func loadConfig(s string) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict([]byte(s), cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate checks the config as a whole.
+func (c *Config) validate() error {
+	if c.Global != nil {
+		if err := c.Global.validate(); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, r := range c.Receivers {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return errors.Errorf("notification config name %q is not unique", r.Name)
+		}
+		names[r.Name] = true
+
+		g := c.Global
+		if g == nil {
+			g = &GlobalConfig{}
+		}
+		for _, ec := range r.EmailConfigs {
+			if ec.Smarthost == "" && g.SMTPSmarthost == "" {
+				return errors.Errorf("no global SMTP smarthost set for receiver %q", r.Name)
+			}
+			if ec.From == "" && g.SMTPFrom == "" {
+				return errors.Errorf("no global SMTP from set for receiver %q", r.Name)
+			}
+		}
+		for _, sc := range r.SlackConfigs {
+			if sc.APIURL == "" && g.SlackAPIURL == "" {
+				return errors.Errorf("no global Slack API URL set for receiver %q", r.Name)
+			}
+		}
+		for _, hc := range r.HipchatConfigs {
+			if hc.AuthToken == "" && g.HipchatAuthToken == "" {
+				return errors.Errorf("no global Hipchat Auth Token set for receiver %q", r.Name)
+			}
+		}
+		for _, oc := range r.OpsGenieConfigs {
+			if oc.APIKey == "" && g.OpsGenieAPIKey == "" {
+				return errors.Errorf("no global OpsGenie API Key set for receiver %q", r.Name)
+			}
+		}
+		for _, vc := range r.VictorOpsConfigs {
+			if vc.APIKey == "" && g.VictorOpsAPIKey == "" {
+				return errors.Errorf("no global VictorOps API Key set for receiver %q", r.Name)
+			}
+		}
+	}
+
+	for _, r := range c.InhibitRules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Route == nil {
+		return errors.New("no routes provided")
+	}
+	if c.Route.Receiver == "" {
+		return errors.New("root route must specify a default receiver")
+	}
+	if len(c.Route.Match) > 0 || len(c.Route.MatchRE) > 0 || len(c.Route.Matchers) > 0 {
+		return errors.New("root route must not have any matchers")
+	}
+	if c.Route.Continue {
+		return errors.New("cannot have continue in root route")
+	}
+	if err := c.Route.validate(); err != nil {
+		return err
+	}
+	if err := checkReceivers(c.Route, names); err != nil {
+		return err
+	}
+
+	intervals := make(map[string]bool)
+	for _, t := range append(append([]*TimeInterval(nil), c.MuteTimeIntervals...), c.TimeIntervals...) {
+		if err := t.validate(); err != nil {
+			return err
+		}
+		if intervals[t.Name] {
+			return errors.Errorf("time interval %q is not unique", t.Name)
+		}
+		intervals[t.Name] = true
+	}
+	for _, name := range c.Route.timeIntervals() {
+		if !intervals[name] {
+			return errors.Errorf("undefined time interval %q used in route", name)
+		}
+	}
+	return nil
+}
+
+// checkReceivers returns an error if r or its children use a receiver that is not defined.
+func checkReceivers(r *Route, names map[string]bool) error {
+	if r.Receiver != "" && !names[r.Receiver] {
+		return errors.Errorf("undefined receiver %q used in route", r.Receiver)
+	}
+
+	seen := make(map[string]bool)
+	for _, l := range r.GroupBy {
+		if seen[l] {
+			return errors.Errorf("duplicated label %q in group_by", l)
+		}
+		seen[l] = true
+	}
+
+	for _, child := range r.Routes {
+		if err := checkReceivers(child, names); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// priorityKey is the annotation that decides which configmap wins a conflict under the
+// priority policy. Higher values win. Ties are won by the first in namespace and name order.
+const priorityKey = "alertmanager-priority"
+
+// how configmaps that define the same object are handled
+const (
+	conflictPolicyError    = "error"
+	conflictPolicyPriority = "priority"
+	conflictPolicyMerge    = "merge"
+)
+
+// conflictError is returned for configmaps that lost a conflict to another configmap.
+// Like ignoredError, it does not cause generation to fail.
+type conflictError string
+
+func (e conflictError) Error() string {
+	return string(e)
+}
+
+func isConflict(err error) bool {
+	_, ok := err.(conflictError)
+	return ok
+}
+
+// conflicts is the outcome of resolving fragments that define the same object.
+type conflicts struct {
+	// fragments that must not be used, with the reason
+	rejected map[*fragment]error
+	// globals that were merged into another fragment's global
+	merged map[*fragment]bool
+	// conflicts that did not stop a fragment from being used
+	warnings map[*fragment][]warning
+}
+
+// resolveConflicts finds globals, default routes, receivers, and time intervals that are defined
+// by more than one fragment and applies the policy in opts for each kind. fragments must be sorted
+// by namespace and name.
+func resolveConflicts(fragments []*fragment, opts *generateOptions) *conflicts {
+	c := &conflicts{
+		rejected: make(map[*fragment]error),
+		merged:   make(map[*fragment]bool),
+		warnings: make(map[*fragment][]warning),
+	}
+
+	var globals, defaults []*fragment
+	var names []string
+	receivers := make(map[string][]*fragment)
+	var intervalNames []string
+	intervals := make(map[string][]*fragment)
+	for _, f := range fragments {
+		switch {
+		case f.Kind == kindGlobal:
+			globals = append(globals, f)
+		case f.Kind == kindRoute && f.DefaultRoute:
+			defaults = append(defaults, f)
+		case f.Kind == kindReceiver:
+			if _, ok := receivers[f.Receiver.Name]; !ok {
+				names = append(names, f.Receiver.Name)
+			}
+			receivers[f.Receiver.Name] = append(receivers[f.Receiver.Name], f)
+		case f.Kind == kindTimeInterval:
+			if _, ok := intervals[f.TimeInterval.Name]; !ok {
+				intervalNames = append(intervalNames, f.TimeInterval.Name)
+			}
+			intervals[f.TimeInterval.Name] = append(intervals[f.TimeInterval.Name], f)
+		}
+	}
+
+	if opts.GlobalConflicts == conflictPolicyMerge {
+		c.mergeGlobals(globals)
+	} else {
+		c.resolve("global", globals, opts.GlobalConflicts)
+	}
+	c.resolve("default route", defaults, opts.DefaultRouteConflicts)
+	for _, name := range names {
+		c.resolve(fmt.Sprintf("receiver %q", name), receivers[name], opts.ReceiverConflicts)
+	}
+	// time intervals have no policy of their own, so every conflicting definition is rejected
+	for _, name := range intervalNames {
+		c.resolve(fmt.Sprintf("time interval %q", name), intervals[name], conflictPolicyError)
+	}
+	return c
+}
+
+// resolve applies policy to fragments that all define what.
+func (c *conflicts) resolve(what string, fs []*fragment, policy string) {
+	if len(fs) < 2 {
+		return
+	}
+
+	if policy == conflictPolicyPriority {
+		fs = byPriority(fs)
+		for _, f := range fs[1:] {
+			c.rejected[f] = conflictError(fmt.Sprintf("%s defined by %s is overridden by %s, which has priority %d", what, f, fs[0], fs[0].Priority))
+		}
+		return
+	}
+
+	// without a way to choose, none of them are used
+	for _, f := range fs {
+		var others []string
+		for _, o := range fs {
+			if o != f {
+				others = append(others, o.String())
+			}
+		}
+		c.rejected[f] = errors.Errorf("%s defined by %s is also defined by %s", what, f, strings.Join(others, ", "))
+	}
+}
+
+// mergeGlobals combines globals field by field. A field set by more than one fragment
+// is taken from the one with the highest priority.
+func (c *conflicts) mergeGlobals(fs []*fragment) {
+	if len(fs) < 2 {
+		return
+	}
+	fs = byPriority(fs)
+
+	merged := &GlobalConfig{}
+	sources := make(map[string]*fragment)
+	mv := reflect.ValueOf(merged).Elem()
+	t := mv.Type()
+
+	for _, f := range fs {
+		fv := reflect.ValueOf(f.Global).Elem()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.Name == "XXX" {
+				for k, v := range f.Global.XXX {
+					if merged.XXX == nil {
+						merged.XXX = make(map[string]interface{})
+					}
+					c.mergeField(f, k, sources, v, merged.XXX[k], func() { merged.XXX[k] = v })
+				}
+				continue
+			}
+
+			// a field explicitly set to its zero value, such as smtp_require_tls: false, still counts
+			name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+			if !f.GlobalFields[name] {
+				continue
+			}
+			v := fv.Field(i)
+			c.mergeField(f, name, sources, v.Interface(), mv.Field(i).Interface(), func() { mv.Field(i).Set(v) })
+		}
+	}
+
+	fs[0].Global = merged
+	for _, f := range fs[1:] {
+		c.merged[f] = true
+	}
+}
+
+// mergeField sets field from f unless an earlier fragment already set it, in which case
+// a differing value is reported as a conflict.
+func (c *conflicts) mergeField(f *fragment, field string, sources map[string]*fragment, value, current interface{}, set func()) {
+	src, ok := sources[field]
+	if !ok {
+		sources[field] = f
+		set()
+		return
+	}
+	if reflect.DeepEqual(value, current) {
+		return
+	}
+	c.warnings[f] = append(c.warnings[f], warning{
+		Reason:  "Conflict",
+		Message: fmt.Sprintf("global %s defined by %s is overridden by %s, which has priority %d", field, f, src, src.Priority),
+	})
+}
+
+// byPriority returns a copy of fs with the highest priority first. Ties keep their order.
+func byPriority(fs []*fragment) []*fragment {
+	fs = append([]*fragment(nil), fs...)
+	sort.SliceStable(fs, func(i, j int) bool {
+		return fs[i].Priority > fs[j].Priority
+	})
+	return fs
+}
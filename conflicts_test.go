@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testGlobal is a global configmap named name with the given priority and spec.
+type testGlobal struct {
+	name     string
+	priority int
+	spec     string
+}
+
+func TestMergeGlobals(t *testing.T) {
+	tests := []struct {
+		name    string
+		globals []testGlobal
+		// merged global, as YAML
+		merged string
+		// the configmaps that get a warning for each field they lost
+		warnings map[string][]string
+	}{
+		{
+			name: "disjoint fields",
+			globals: []testGlobal{
+				{name: "a", spec: "smtp_from: a@example.org"},
+				{name: "b", spec: "slack_api_url: https://slack.example.org"},
+			},
+			merged: "smtp_from: a@example.org\nslack_api_url: https://slack.example.org\nsmtp_require_tls: false",
+		},
+		{
+			name: "higher priority wins",
+			globals: []testGlobal{
+				{name: "a", spec: "smtp_from: a@example.org\nresolve_timeout: 1m"},
+				{name: "b", priority: 10, spec: "smtp_from: b@example.org"},
+			},
+			merged: "smtp_from: b@example.org\nresolve_timeout: 1m\nsmtp_require_tls: false",
+			warnings: map[string][]string{
+				"a": {"global smtp_from defined by default/a is overridden by default/b, which has priority 10"},
+			},
+		},
+		{
+			name: "ties are won by name order",
+			globals: []testGlobal{
+				{name: "a", spec: "smtp_from: a@example.org"},
+				{name: "b", spec: "smtp_from: b@example.org"},
+			},
+			merged: "smtp_from: a@example.org\nsmtp_require_tls: false",
+			warnings: map[string][]string{
+				"b": {"global smtp_from defined by default/b is overridden by default/a, which has priority 0"},
+			},
+		},
+		{
+			name: "same value is not a conflict",
+			globals: []testGlobal{
+				{name: "a", spec: "smtp_from: a@example.org"},
+				{name: "b", priority: 1, spec: "smtp_from: a@example.org"},
+			},
+			merged: "smtp_from: a@example.org\nsmtp_require_tls: false",
+		},
+		{
+			name: "explicit zero value",
+			globals: []testGlobal{
+				{name: "a", spec: "smtp_require_tls: true"},
+				{name: "b", priority: 1, spec: "smtp_require_tls: false"},
+			},
+			merged: "smtp_require_tls: false",
+			warnings: map[string][]string{
+				"a": {"global smtp_require_tls defined by default/a is overridden by default/b, which has priority 1"},
+			},
+		},
+		{
+			name: "unset field does not override",
+			globals: []testGlobal{
+				{name: "a", spec: "smtp_require_tls: true"},
+				{name: "b", priority: 1, spec: "smtp_from: b@example.org"},
+			},
+			merged: "smtp_from: b@example.org\nsmtp_require_tls: true",
+		},
+		{
+			name: "unknown fields",
+			globals: []testGlobal{
+				{name: "a", spec: "telegram_api_url: https://a.example.org\nwechat_api_url: https://wechat.example.org"},
+				{name: "b", priority: 1, spec: "telegram_api_url: https://b.example.org"},
+			},
+			merged: "telegram_api_url: https://b.example.org\nwechat_api_url: https://wechat.example.org\nsmtp_require_tls: false",
+			warnings: map[string][]string{
+				"a": {"global telegram_api_url defined by default/a is overridden by default/b, which has priority 1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fs []*fragment
+			byName := make(map[string]*fragment)
+			for _, g := range tt.globals {
+				cm := newConfigMap("default", g.name)
+				cm.Metadata.Annotations[typeAnnotationKey] = kindGlobal
+				cm.Metadata.Annotations[priorityKey] = strconv.Itoa(g.priority)
+				cm.Data[specAnnotationKey] = g.spec
+				f, err := parseFragment(cm, &generateOptions{UnknownFields: unknownFieldsKeep})
+				if err != nil {
+					t.Fatal(err)
+				}
+				fs = append(fs, f)
+				byName[g.name] = f
+			}
+
+			c := resolveConflicts(fs, &generateOptions{GlobalConflicts: conflictPolicyMerge})
+
+			var winner *fragment
+			for _, f := range fs {
+				if !c.merged[f] {
+					if winner != nil {
+						t.Fatalf("both %s and %s were used", winner, f)
+					}
+					winner = f
+				}
+			}
+			if winner == nil {
+				t.Fatal("no global was used")
+			}
+
+			out, err := yaml.Marshal(winner.Global)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want map[string]interface{}
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.merged), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got merged global\n%s\nwant\n%s", out, tt.merged)
+			}
+
+			for name, f := range byName {
+				var messages []string
+				for _, w := range c.warnings[f] {
+					messages = append(messages, w.Message)
+				}
+				if !reflect.DeepEqual(messages, tt.warnings[name]) {
+					t.Errorf("got warnings %q for %s, want %q", messages, name, tt.warnings[name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// how long to wait for Alertmanager to respond to a reload
+const reloadTimeout = 30 * time.Second
+
+// writeFile writes the generated config to the output file, if it changed,
+// and tells Alertmanager to reload it.
+func (c *controller) writeFile(cm *ConfigMap) error {
+	existing := newConfigMap(c.targetNamespace, c.targetName)
+	data, err := ioutil.ReadFile(c.outputFile)
+	switch {
+	case err == nil:
+		existing.Data[configFileKey] = string(data)
+	case !os.IsNotExist(err):
+		return errors.Wrapf(err, "failed to read %s", c.outputFile)
+	}
+
+	if !compareConfigMaps(existing, cm) {
+		if err := writeFileAtomic(c.outputFile, []byte(cm.Data[configFileKey])); err != nil {
+			return err
+		}
+		log.Printf("wrote %s", c.outputFile)
+		c.reloadPending = true
+	}
+
+	// a failed reload is retried on the next run even if the file has not changed again
+	if !c.reloadPending {
+		return nil
+	}
+	for _, u := range c.reloadURLs {
+		if err := reloadAlertmanager(u); err != nil {
+			return err
+		}
+	}
+	c.reloadPending = false
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// filename and renames it, so readers never see a partially written file.
+func writeFileAtomic(filename string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "failed to write %s", f.Name())
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "failed to sync %s", f.Name())
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "failed to close %s", f.Name())
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "failed to chmod %s", f.Name())
+	}
+
+	if err := os.Rename(f.Name(), filename); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrapf(err, "failed to rename %s to %s", f.Name(), filename)
+	}
+	return nil
+}
+
+// reloadAlertmanager asks the Alertmanager at baseURL to reload its config.
+func reloadAlertmanager(baseURL string) error {
+	client := &http.Client{Timeout: reloadTimeout}
+	resp, err := client.Post(strings.TrimSuffix(baseURL, "/")+"/-/reload", "", nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to reload alertmanager %s", baseURL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed to reload alertmanager %s; got HTTP %v status code: %s", baseURL, resp.StatusCode, body)
+	}
+	log.Printf("reloaded alertmanager %s", baseURL)
+	return nil
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// values of the type annotation, lowercased
+const (
+	kindGlobal       = "global"
+	kindInhibitRule  = "inhibitrule"
+	kindReceiver     = "receiver"
+	kindTemplate     = "template"
+	kindRoute        = "route"
+	kindTimeInterval = "timeinterval"
+)
+
+// how fields the controller does not know about are handled
+const (
+	unknownFieldsDrop   = "drop"
+	unknownFieldsReject = "reject"
+	unknownFieldsKeep   = "keep"
+)
+
+// generateOptions control how source configmaps are combined.
+type generateOptions struct {
+	// skip invalid configmaps rather than failing
+	Tolerant bool
+	// one of unknownFieldsDrop, unknownFieldsReject, or unknownFieldsKeep
+	UnknownFields string
+	// if set, routes are limited to alerts with this label set to their namespace
+	NamespaceLabel string
+	// namespaces whose routes are not limited
+	UnscopedNamespaces []string
+	// if set, receivers are renamed using this format. {namespace} and {name}
+	// are replaced with the namespace and name of the receiver.
+	ReceiverNameFormat string
+	// how conflicting globals, default routes, and receivers are handled.
+	// one of conflictPolicyError, conflictPolicyPriority, or conflictPolicyMerge for globals.
+	GlobalConflicts       string
+	DefaultRouteConflicts string
+	ReceiverConflicts     string
+}
+
+// ignoredError is returned for configmaps that are not used but should not
+// cause generation to fail, such as those with an unknown type.
+type ignoredError string
+
+func (e ignoredError) Error() string {
+	return string(e)
+}
+
+func isIgnored(err error) bool {
+	_, ok := err.(ignoredError)
+	return ok
+}
+
+// fragment is a single parsed source configmap. Only the field for its kind is set.
+type fragment struct {
+	ConfigMap *ConfigMap
+	Kind      string
+
+	Global *GlobalConfig
+	// GlobalFields are the global fields set in the configmap, even to their zero value
+	GlobalFields map[string]bool
+	InhibitRule  *InhibitRule
+	Receiver     *Receiver
+	Template     string
+	TimeInterval *TimeInterval
+	Route        *Route
+	// DefaultRoute is true if Route is the top level route
+	DefaultRoute bool
+	// Order of a route among its siblings. Lower comes first.
+	Order int
+	// Priority decides which fragment wins a conflict. Higher wins.
+	Priority int
+}
+
+// String returns the namespace and name of the source configmap.
+func (f *fragment) String() string {
+	return configMapKey(f.ConfigMap)
+}
+
+func configMapKey(cm *ConfigMap) string {
+	return cm.Metadata.Namespace + "/" + cm.Metadata.Name
+}
+
+// parseFragment reads the object defined by cm.
+func parseFragment(cm *ConfigMap, opts *generateOptions) (*fragment, error) {
+	f := &fragment{
+		ConfigMap: cm,
+		Kind:      strings.ToLower(cm.Metadata.Annotations[typeAnnotationKey]),
+	}
+
+	if p := cm.Metadata.Annotations[priorityKey]; p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, errors.Errorf("invalid %s %q for %s/%s", priorityKey, p, cm.Metadata.Namespace, cm.Metadata.Name)
+		}
+		f.Priority = priority
+	}
+
+	var rc bool
+	var err error
+
+	// XXX: this is clumsy
+	switch f.Kind {
+	case kindGlobal:
+		f.Global = &GlobalConfig{}
+		rc, err = readObject(cm, f.Global)
+		if rc && err == nil {
+			f.GlobalFields, err = specKeys(cm)
+		}
+	case kindInhibitRule:
+		f.InhibitRule = &InhibitRule{}
+		rc, err = readObject(cm, f.InhibitRule)
+	case kindReceiver:
+		f.Receiver = &Receiver{}
+		rc, err = readObject(cm, f.Receiver)
+	case kindTemplate:
+		rc, err = readObject(cm, &f.Template)
+		rc = rc && f.Template != ""
+	case kindTimeInterval:
+		f.TimeInterval = &TimeInterval{}
+		rc, err = readObject(cm, f.TimeInterval)
+	case kindRoute:
+		f.Route = &Route{}
+		rc, err = readObject(cm, f.Route)
+		f.DefaultRoute = cm.Metadata.Annotations[routeDefaultKey] == "true"
+		if o := cm.Metadata.Annotations[routeOrderKey]; o != "" {
+			order, cerr := strconv.Atoi(o)
+			if cerr != nil {
+				return nil, errors.Errorf("invalid %s %q for %s/%s", routeOrderKey, o, cm.Metadata.Namespace, cm.Metadata.Name)
+			}
+			f.Order = order
+		}
+	default:
+		return nil, ignoredError(fmt.Sprintf("unknown %s %q", typeAnnotationKey, cm.Metadata.Annotations[typeAnnotationKey]))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if !rc {
+		return nil, ignoredError(fmt.Sprintf("no %s key", specAnnotationKey))
+	}
+
+	if f.Kind == kindTemplate {
+		return f, nil
+	}
+	switch opts.UnknownFields {
+	case unknownFieldsKeep:
+	case unknownFieldsReject:
+		if fields := unknownFields(f.object(), f.Kind, false); len(fields) > 0 {
+			return nil, errors.Errorf("unknown fields in %s/%s: %s", cm.Metadata.Namespace, cm.Metadata.Name, strings.Join(fields, ", "))
+		}
+	default:
+		if fields := unknownFields(f.object(), f.Kind, true); len(fields) > 0 {
+			log.Printf("dropping unknown fields in %s/%s: %s", cm.Metadata.Namespace, cm.Metadata.Name, strings.Join(fields, ", "))
+		}
+	}
+	switch f.Kind {
+	case kindRoute:
+		f.Route.setSource(cm)
+	case kindInhibitRule:
+		f.InhibitRule.source = cm
+	}
+	return f, nil
+}
+
+// object returns the parsed object for the fragment's kind.
+func (f *fragment) object() interface{} {
+	switch f.Kind {
+	case kindGlobal:
+		return f.Global
+	case kindInhibitRule:
+		return f.InhibitRule
+	case kindReceiver:
+		return f.Receiver
+	case kindRoute:
+		return f.Route
+	case kindTimeInterval:
+		return f.TimeInterval
+	}
+	return f.Template
+}
+
+// unknownFields returns the path of every field that was caught by an XXX field in o.
+// Every config struct has an inline XXX map that catches the fields it does not define,
+// so they can be kept, dropped, or rejected as set by --unknown-fields.
+// If clear is set, the fields are removed so they are not written to the generated config.
+func unknownFields(o interface{}, path string, clear bool) []string {
+	return walkUnknownFields(reflect.ValueOf(o), path, clear)
+}
+
+func walkUnknownFields(v reflect.Value, path string, clear bool) []string {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return walkUnknownFields(v.Elem(), path, clear)
+
+	case reflect.Slice:
+		var fields []string
+		for i := 0; i < v.Len(); i++ {
+			fields = append(fields, walkUnknownFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), clear)...)
+		}
+		return fields
+
+	case reflect.Struct:
+		var fields []string
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			fv := v.Field(i)
+			tag := strings.Split(sf.Tag.Get("yaml"), ",")
+			if sf.PkgPath != "" {
+				// unexported fields are not part of the config
+				continue
+			}
+
+			if sf.Name == "XXX" {
+				var keys []string
+				for _, k := range fv.MapKeys() {
+					keys = append(keys, path+"."+fmt.Sprint(k.Interface()))
+				}
+				sort.Strings(keys)
+				fields = append(fields, keys...)
+				if clear && fv.CanSet() {
+					fv.Set(reflect.Zero(fv.Type()))
+				}
+				continue
+			}
+
+			p := path
+			if !(len(tag) > 1 && tag[1] == "inline") {
+				name := tag[0]
+				if name == "" {
+					name = strings.ToLower(sf.Name)
+				}
+				p = path + "." + name
+			}
+			fields = append(fields, walkUnknownFields(fv, p, clear)...)
+		}
+		return fields
+	}
+	return nil
+}
+
+// validate checks the fragment on its own, without regard to other fragments.
+func (f *fragment) validate() error {
+	var err error
+	switch f.Kind {
+	case kindGlobal:
+		err = f.Global.validate()
+	case kindInhibitRule:
+		err = f.InhibitRule.validate()
+	case kindReceiver:
+		err = f.Receiver.validate()
+	case kindTimeInterval:
+		err = f.TimeInterval.validate()
+	case kindRoute:
+		err = f.Route.validate()
+		if err == nil && f.DefaultRoute && f.Route.Receiver == "" {
+			err = errors.New("default route must have a receiver")
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s %s", f.Kind, f)
+	}
+	return nil
+}
+
+// generateConfig builds the config from the source configmaps. The returned results record
+// which configmaps were used and why any were rejected. Unless opts.Tolerant is set, the
+// first rejected configmap causes an error. When tolerant, rejected configmaps and
+// routes that use their receivers are skipped.
+func generateConfig(items []*ConfigMap, opts *generateOptions) (*Config, []*fragmentResult, error) {
+	var results []*fragmentResult
+	var fragments []*fragment
+
+	reject := func(cm *ConfigMap, err error) error {
+		results = append(results, &fragmentResult{ConfigMap: cm, Err: err})
+		if opts.Tolerant || isIgnored(err) || isConflict(err) {
+			return nil
+		}
+		return err
+	}
+
+	// receivers that were rejected. routes using them must be skipped
+	// or alerts would be routed to a receiver that does not exist.
+	rejectedReceivers := make(map[string]*ConfigMap)
+	// likewise for time intervals
+	rejectedIntervals := make(map[string]*ConfigMap)
+
+	// sort so the output does not depend on the order configmaps were listed in
+	items = append([]*ConfigMap(nil), items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].Metadata, items[j].Metadata
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	receivers := newReceiverIndex()
+
+	for _, cm := range items {
+		f, err := parseFragment(cm, opts)
+		if f == nil && strings.ToLower(cm.Metadata.Annotations[typeAnnotationKey]) == kindReceiver {
+			// routes using a receiver that could not be parsed are skipped too, if its name can be read
+			f = &fragment{ConfigMap: cm, Kind: kindReceiver, Receiver: &Receiver{Name: specName(cm)}}
+		}
+		if err == nil && f.Kind == kindRoute {
+			err = scopeRoute(f, opts)
+		}
+		if err == nil {
+			err = f.validate()
+		}
+		// a receiver is only renamed once it is known to have a name, but rejected receivers
+		// are still renamed so routes using them are rewritten and skipped. A receiver whose
+		// qualified name is taken keeps its name, and routes using it are rejected instead.
+		if f != nil && f.Kind == kindReceiver && f.Receiver.Name != "" {
+			var qerr error
+			if opts.ReceiverNameFormat != "" {
+				qerr = receivers.qualify(f, opts)
+			}
+			if qerr == nil && err != nil {
+				rejectedReceivers[f.Receiver.Name] = cm
+			}
+			if err == nil && qerr != nil {
+				err = errors.Wrapf(qerr, "invalid receiver %s", f)
+			}
+		}
+		if err != nil && f != nil && f.Kind == kindTimeInterval && f.TimeInterval.Name != "" {
+			rejectedIntervals[f.TimeInterval.Name] = cm
+		}
+		if err != nil {
+			if err := reject(cm, err); err != nil {
+				return nil, results, err
+			}
+			continue
+		}
+		fragments = append(fragments, f)
+	}
+
+	// routes can only be rewritten once every receiver is known
+	if opts.ReceiverNameFormat != "" {
+		var qualified []*fragment
+		for _, f := range fragments {
+			if f.Kind == kindRoute {
+				if err := receivers.qualifyRoute(f, opts); err != nil {
+					if err := reject(f.ConfigMap, errors.Wrapf(err, "invalid route %s", f)); err != nil {
+						return nil, results, err
+					}
+					continue
+				}
+			}
+			qualified = append(qualified, f)
+		}
+		fragments = qualified
+	}
+
+	// losing default routes are still passed on so routes under them are attached to the winner
+	conflicts := resolveConflicts(fragments, opts)
+	var resolved []*fragment
+	for _, f := range fragments {
+		err, ok := conflicts.rejected[f]
+		if !ok || f.Kind == kindRoute {
+			resolved = append(resolved, f)
+			continue
+		}
+		if err := reject(f.ConfigMap, err); err != nil {
+			return nil, results, err
+		}
+		// receivers that are defined by none of their configmaps can not be used
+		if f.Kind == kindReceiver && !isConflict(err) {
+			rejectedReceivers[f.Receiver.Name] = f.ConfigMap
+		}
+		if f.Kind == kindTimeInterval && !isConflict(err) {
+			rejectedIntervals[f.TimeInterval.Name] = f.ConfigMap
+		}
+	}
+	fragments = resolved
+
+	// a receiver or time interval that is also defined by an accepted configmap is still usable
+	defined := make(map[string]bool)
+	definedIntervals := make(map[string]bool)
+	for _, f := range fragments {
+		switch f.Kind {
+		case kindReceiver:
+			delete(rejectedReceivers, f.Receiver.Name)
+			defined[f.Receiver.Name] = true
+		case kindTimeInterval:
+			delete(rejectedIntervals, f.TimeInterval.Name)
+			definedIntervals[f.TimeInterval.Name] = true
+		}
+	}
+
+	var accepted, routes []*fragment
+
+	for _, f := range fragments {
+		if f.Kind != kindRoute {
+			accepted = append(accepted, f)
+			continue
+		}
+		if _, ok := conflicts.rejected[f]; ok {
+			routes = append(routes, f)
+			continue
+		}
+		err := checkRouteReceivers(f, defined, rejectedReceivers)
+		if err == nil {
+			err = checkRouteTimeIntervals(f, definedIntervals, rejectedIntervals)
+		}
+		if err != nil {
+			if err := reject(f.ConfigMap, err); err != nil {
+				return nil, results, err
+			}
+			continue
+		}
+		routes = append(routes, f)
+	}
+
+	root, rejectedRoutes := buildRouteTree(routes, conflicts.rejected)
+	for _, f := range routes {
+		err, ok := rejectedRoutes[f]
+		if !ok {
+			accepted = append(accepted, f)
+			continue
+		}
+		if err := reject(f.ConfigMap, err); err != nil {
+			return nil, results, err
+		}
+	}
+
+	warnings := make(map[*fragment][]warning)
+	for f, w := range conflicts.warnings {
+		warnings[f] = w
+	}
+	if root != nil {
+		for f, w := range unusedReceivers(root, accepted) {
+			warnings[f] = append(warnings[f], w)
+		}
+	}
+
+	cfg := &Config{}
+	for _, f := range accepted {
+		switch f.Kind {
+		case kindGlobal:
+			if !conflicts.merged[f] {
+				cfg.Global = f.Global
+			}
+		case kindInhibitRule:
+			cfg.InhibitRules = append(cfg.InhibitRules, f.InhibitRule)
+		case kindReceiver:
+			cfg.Receivers = append(cfg.Receivers, f.Receiver)
+		case kindTemplate:
+			cfg.Templates = append(cfg.Templates, f.Template)
+		case kindTimeInterval:
+			cfg.TimeIntervals = append(cfg.TimeIntervals, f.TimeInterval)
+		}
+		results = append(results, &fragmentResult{ConfigMap: f.ConfigMap, Warnings: warnings[f]})
+	}
+
+	if root == nil {
+		return nil, results, errors.New("no default route found")
+	}
+	cfg.Route = root
+
+	return cfg, results, nil
+}
+
+// rejectionReport returns an error listing every rejected configmap, or nil if none were.
+// Configmaps that lost a conflict to another were not rejected, so they are listed separately.
+func rejectionReport(results []*fragmentResult) error {
+	var rejected, overridden []string
+	for _, r := range results {
+		switch {
+		case r.Err == nil || isIgnored(r.Err):
+		case isConflict(r.Err):
+			overridden = append(overridden, r.Err.Error())
+		default:
+			rejected = append(rejected, r.Err.Error())
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("%d config maps rejected:\n\t%s", len(rejected), strings.Join(rejected, "\n\t"))
+	if len(overridden) > 0 {
+		msg += fmt.Sprintf("\n%d config maps overridden:\n\t%s", len(overridden), strings.Join(overridden, "\n\t"))
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// projected tokens are rotated by the kubelet, so reread periodically
+	tokenRefreshInterval = time.Minute
+)
+
+// newInClusterClient creates a client using the pod's service account.
+func newInClusterClient() (*k8sClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set to run in cluster")
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read service account CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in service account CA")
+	}
+
+	namespace, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read service account namespace")
+	}
+
+	token := &tokenFile{path: serviceAccountDir + "/token"}
+	if _, err := token.get(); err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}
+
+	return &k8sClient{
+		endpoint:  "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		client: &http.Client{
+			Transport: &bearerRoundTripper{token: token.get, next: transport},
+		},
+	}, nil
+}
+
+// tokenFile reads a bearer token from a file, rereading it when it may have been rotated.
+type tokenFile struct {
+	path string
+
+	sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func (t *tokenFile) get() (string, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.token != "" && time.Now().Before(t.expiry) {
+		return t.token, nil
+	}
+
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		// keep using the old token rather than failing every request
+		if t.token != "" {
+			return t.token, nil
+		}
+		return "", errors.Wrapf(err, "failed to read token %s", t.path)
+	}
+	t.token = strings.TrimSpace(string(data))
+	t.expiry = time.Now().Add(tokenRefreshInterval)
+	return t.token, nil
+}
+
+// bearerRoundTripper sets the Authorization header on each request.
+type bearerRoundTripper struct {
+	token func() (string, error)
+	next  http.RoundTripper
+}
+
+func (b *bearerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := b.token()
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the original request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return b.next.RoundTrip(r)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	inhibitTestAlerts string
+	inhibitTestFormat string
+)
+
+var inhibitCmd = &cobra.Command{
+	Use:   "inhibit",
+	Short: "Commands for inspecting inhibit rules",
+}
+
+var inhibitTestCmd = &cobra.Command{
+	Use:   "test path...",
+	Short: "Shows which of a set of firing alerts would be inhibited",
+	Long: `Shows which of the alerts in the file set by --alerts would be inhibited by the inhibit rules
+generated from configmap manifests in local files, directories, or globs. Resolved alerts are skipped.`,
+	Run: runInhibitTest,
+}
+
+// inhibition is a rule that inhibits an alert.
+type inhibition struct {
+	// Rule is the position of the rule in inhibit_rules
+	Rule      int    `json:"rule"`
+	ConfigMap string `json:"configMap,omitempty"`
+	// Source is the labels of the alert that caused the inhibition
+	Source map[string]string `json:"source"`
+}
+
+// inhibitedAlert is an alert that would be inhibited, with every rule that inhibits it.
+type inhibitedAlert struct {
+	Labels      map[string]string `json:"labels"`
+	InhibitedBy []*inhibition     `json:"inhibitedBy"`
+}
+
+func runInhibitTest(cmd *cobra.Command, args []string) {
+	if inhibitTestAlerts == "" {
+		log.Fatal("--alerts is required")
+	}
+	if inhibitTestFormat != formatText && inhibitTestFormat != formatJSON {
+		log.Fatalf("unknown value for --format %s", inhibitTestFormat)
+	}
+
+	alerts, err := readAlerts(inhibitTestAlerts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := generateLocal(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var firing []*alert
+	for _, a := range alerts {
+		if !a.Resolved {
+			firing = append(firing, a)
+		}
+	}
+
+	inhibited := inhibitAlerts(cfg.InhibitRules, firing)
+	if inhibitTestFormat == formatJSON {
+		if inhibited == nil {
+			inhibited = []*inhibitedAlert{}
+		}
+		err = writeJSON(os.Stdout, inhibited)
+	} else {
+		err = writeInhibitedAlerts(os.Stdout, len(firing), inhibited)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// inhibitAlerts returns the alerts that would be inhibited by rules while all of alerts are firing,
+// following Alertmanager's rules. An alert that matches both sides of a rule is not inhibited by
+// other alerts that also match both sides, so alerts can not inhibit each other.
+func inhibitAlerts(rules []*InhibitRule, alerts []*alert) []*inhibitedAlert {
+	var inhibited []*inhibitedAlert
+	for _, target := range alerts {
+		var by []*inhibition
+		for i, r := range rules {
+			if !labelsMatch(target.Labels, r.TargetMatch, r.TargetMatchRE, r.TargetMatchers) {
+				continue
+			}
+			twoSided := labelsMatch(target.Labels, r.SourceMatch, r.SourceMatchRE, r.SourceMatchers)
+
+			for _, source := range alerts {
+				if source == target || !labelsMatch(source.Labels, r.SourceMatch, r.SourceMatchRE, r.SourceMatchers) {
+					continue
+				}
+				if twoSided && labelsMatch(source.Labels, r.TargetMatch, r.TargetMatchRE, r.TargetMatchers) {
+					continue
+				}
+				if !equalLabels(r.Equal, source.Labels, target.Labels) {
+					continue
+				}
+
+				in := &inhibition{Rule: i, Source: source.Labels}
+				if r.source != nil {
+					in.ConfigMap = configMapKey(r.source)
+				}
+				by = append(by, in)
+				break
+			}
+		}
+		if len(by) > 0 {
+			inhibited = append(inhibited, &inhibitedAlert{Labels: target.Labels, InhibitedBy: by})
+		}
+	}
+	return inhibited
+}
+
+// equalLabels returns true if a and b have the same value for every label in names.
+// A label missing from both is equal.
+func equalLabels(names []string, a, b map[string]string) bool {
+	for _, n := range names {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeInhibitedAlerts writes inhibited as text.
+func writeInhibitedAlerts(w io.Writer, firing int, inhibited []*inhibitedAlert) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d firing alerts would be inhibited\n", len(inhibited), firing)
+	for _, a := range inhibited {
+		fmt.Fprintf(&b, "\n%s\n", formatLabels(a.Labels))
+		for _, in := range a.InhibitedBy {
+			source := in.ConfigMap
+			if source == "" {
+				source = "unknown"
+			}
+			fmt.Fprintf(&b, "  inhibited by inhibit_rules[%d] (%s) because of %s\n", in.Rule, source, formatLabels(in.Source))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInhibitAlerts(t *testing.T) {
+	critical := map[string]string{"alertname": "Down", "severity": "critical", "cluster": "a"}
+	warning := map[string]string{"alertname": "Slow", "severity": "warning", "cluster": "a"}
+	otherCluster := map[string]string{"alertname": "Slow", "severity": "warning", "cluster": "b"}
+	info := map[string]string{"alertname": "Info", "severity": "info"}
+
+	cm := newConfigMap("monitoring", "inhibit")
+	criticalInhibitsWarning := &InhibitRule{
+		SourceMatch: map[string]string{"severity": "critical"},
+		TargetMatch: map[string]string{"severity": "warning"},
+		Equal:       []string{"cluster"},
+		source:      cm,
+	}
+	// alerts of any severity inhibit warnings, so a warning matches both sides
+	anyInhibitsWarning := &InhibitRule{
+		SourceMatchers: []string{`severity=~"critical|warning"`},
+		TargetMatchers: []string{`severity="warning"`},
+	}
+
+	tests := []struct {
+		name   string
+		rules  []*InhibitRule
+		alerts []map[string]string
+		// the rules that inhibit each alert, by its labels
+		want map[string][]int
+	}{
+		{
+			name:   "source inhibits target with equal labels",
+			rules:  []*InhibitRule{criticalInhibitsWarning},
+			alerts: []map[string]string{critical, warning, otherCluster, info},
+			want:   map[string][]int{formatLabels(warning): {0}},
+		},
+		{
+			name:   "no source firing",
+			rules:  []*InhibitRule{criticalInhibitsWarning},
+			alerts: []map[string]string{warning},
+		},
+		{
+			name: "labels missing from both are equal",
+			rules: []*InhibitRule{{
+				SourceMatch: map[string]string{"severity": "critical"},
+				TargetMatch: map[string]string{"severity": "info"},
+				Equal:       []string{"cluster"},
+			}},
+			alerts: []map[string]string{critical, info, {"severity": "critical"}},
+			want:   map[string][]int{formatLabels(info): {0}},
+		},
+		{
+			name:   "an alert does not inhibit itself",
+			rules:  []*InhibitRule{anyInhibitsWarning},
+			alerts: []map[string]string{warning},
+		},
+		{
+			name:   "alerts matching both sides do not inhibit each other",
+			rules:  []*InhibitRule{anyInhibitsWarning},
+			alerts: []map[string]string{warning, otherCluster},
+		},
+		{
+			name:   "an alert matching both sides is inhibited by one matching only the source",
+			rules:  []*InhibitRule{anyInhibitsWarning},
+			alerts: []map[string]string{warning, otherCluster, critical},
+			want: map[string][]int{
+				formatLabels(warning):      {0},
+				formatLabels(otherCluster): {0},
+			},
+		},
+		{
+			name:   "every inhibiting rule is listed",
+			rules:  []*InhibitRule{criticalInhibitsWarning, anyInhibitsWarning},
+			alerts: []map[string]string{critical, warning, otherCluster},
+			want: map[string][]int{
+				formatLabels(warning):      {0, 1},
+				formatLabels(otherCluster): {1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var alerts []*alert
+			for _, labels := range tt.alerts {
+				alerts = append(alerts, &alert{Labels: labels})
+			}
+
+			got := make(map[string][]int)
+			for _, a := range inhibitAlerts(tt.rules, alerts) {
+				for _, in := range a.InhibitedBy {
+					got[formatLabels(a.Labels)] = append(got[formatLabels(a.Labels)], in.Rule)
+					if r := tt.rules[in.Rule]; r.source != nil && in.ConfigMap != configMapKey(r.source) {
+						t.Errorf("got configmap %q for rule %d, want %q", in.ConfigMap, in.Rule, configMapKey(r.source))
+					}
+				}
+			}
+			want := tt.want
+			if want == nil {
+				want = map[string][]int{}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
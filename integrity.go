@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// labelNamePattern matches valid Prometheus label names.
+const labelNamePattern = "[a-zA-Z_][a-zA-Z0-9_]*"
+
+var labelNameRE = regexp.MustCompile("^" + labelNamePattern + "$")
+
+// checkRouteReceivers returns an error if the route in f uses a receiver that is not in defined,
+// or that is in rejected.
+func checkRouteReceivers(f *fragment, defined map[string]bool, rejected map[string]*ConfigMap) error {
+	for _, name := range f.Route.receivers() {
+		if cm, ok := rejected[name]; ok {
+			return errors.Errorf("route %s uses receiver %s from rejected %s/%s", f, name, cm.Metadata.Namespace, cm.Metadata.Name)
+		}
+		if !defined[name] {
+			return errors.Errorf("route %s uses undefined receiver %s", f, name)
+		}
+	}
+	return nil
+}
+
+// checkRouteTimeIntervals returns an error if the route in f uses a time interval that is not in
+// defined, or that is in rejected.
+func checkRouteTimeIntervals(f *fragment, defined map[string]bool, rejected map[string]*ConfigMap) error {
+	for _, name := range f.Route.timeIntervals() {
+		if cm, ok := rejected[name]; ok {
+			return errors.Errorf("route %s uses time interval %s from rejected %s/%s", f, name, cm.Metadata.Namespace, cm.Metadata.Name)
+		}
+		if !defined[name] {
+			return errors.Errorf("route %s uses undefined time interval %s", f, name)
+		}
+	}
+	return nil
+}
+
+// unusedReceivers returns a warning for each receiver fragment whose receiver is not used by any route under root.
+func unusedReceivers(root *Route, fragments []*fragment) map[*fragment]warning {
+	used := make(map[string]bool)
+	for _, name := range root.receivers() {
+		used[name] = true
+	}
+
+	unused := make(map[*fragment]warning)
+	for _, f := range fragments {
+		if f.Kind == kindReceiver && !used[f.Receiver.Name] {
+			unused[f] = warning{
+				Reason:  "UnusedReceiver",
+				Message: fmt.Sprintf("receiver %s defined by %s is not used by any route", f.Receiver.Name, f),
+			}
+		}
+	}
+	return unused
+}
+
+// validateLabelNames returns an error if any key in m is not a valid label name.
+func validateLabelNames(field string, m map[string]string) error {
+	for k := range m {
+		if !labelNameRE.MatchString(k) {
+			return errors.Errorf("invalid label name %q in %s", k, field)
+		}
+	}
+	return nil
+}
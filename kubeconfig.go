@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// kubeConfig is the subset of a kubeconfig file the controller understands.
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// newKubeConfigClient creates a client using the given context in a kubeconfig file.
+// The current context is used if contextName is empty.
+func newKubeConfigClient(path, contextName string) (*k8sClient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read kubeconfig %s", path)
+	}
+
+	var kc kubeConfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse kubeconfig %s", path)
+	}
+
+	if contextName == "" {
+		contextName = kc.CurrentContext
+	}
+	if contextName == "" {
+		return nil, errors.Errorf("no context given and no current-context set in %s", path)
+	}
+
+	var clusterName, userName, namespace string
+	found := false
+	for _, c := range kc.Contexts {
+		if c.Name == contextName {
+			clusterName, userName, namespace = c.Context.Cluster, c.Context.User, c.Context.Namespace
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("context %s not found in %s", contextName, path)
+	}
+
+	// relative paths in a kubeconfig are relative to the file
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	tlsConfig := &tls.Config{}
+	var server string
+	found = false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+		server = c.Cluster.Server
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+
+		ca, err := fileOrData(resolve(c.Cluster.CertificateAuthority), c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load certificate authority for cluster %s", clusterName)
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, errors.Errorf("no certificates found in certificate authority for cluster %s", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		break
+	}
+	if !found {
+		return nil, errors.Errorf("cluster %s not found in %s", clusterName, path)
+	}
+	if server == "" {
+		return nil, errors.Errorf("no server set for cluster %s", clusterName)
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+
+	found = false
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+		found = true
+
+		cert, err := fileOrData(resolve(u.User.ClientCertificate), u.User.ClientCertificateData)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load client certificate for user %s", userName)
+		}
+		key, err := fileOrData(resolve(u.User.ClientKey), u.User.ClientKeyData)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load client key for user %s", userName)
+		}
+		if cert != nil || key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid client certificate for user %s", userName)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+
+		switch {
+		case u.User.Token != "":
+			token := u.User.Token
+			transport = &bearerRoundTripper{
+				token: func() (string, error) { return token, nil },
+				next:  transport,
+			}
+		case u.User.TokenFile != "":
+			t := &tokenFile{path: resolve(u.User.TokenFile)}
+			if _, err := t.get(); err != nil {
+				return nil, err
+			}
+			transport = &bearerRoundTripper{token: t.get, next: transport}
+		}
+		break
+	}
+	// a context may leave out the user to connect without credentials
+	if userName != "" && !found {
+		return nil, errors.Errorf("user %s not found in %s", userName, path)
+	}
+
+	return &k8sClient{
+		endpoint:  server,
+		namespace: namespace,
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// fileOrData returns the base64 decoded data, if set, or the contents of path.
+// nil is returned if neither is set.
+func fileOrData(path, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path != "" {
+		return ioutil.ReadFile(path)
+	}
+	return nil, nil
+}
@@ -17,11 +17,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
@@ -30,6 +33,32 @@ import (
 // ErrNotExist is an error returned when an object does not exist.
 var ErrNotExist = errors.New("object does not exist")
 
+// ErrConflict is returned when an update is rejected because the object was
+// modified since it was read.
+var ErrConflict = errors.New("object has been modified")
+
+// ErrAlreadyExists is returned when creating an object that already exists.
+var ErrAlreadyExists = errors.New("object already exists")
+
+// ErrGone is returned when a watch is started from a resource version that
+// is too old. The caller should list again and restart the watch.
+var ErrGone = errors.New("resource version too old")
+
+// watch event types
+const (
+	eventAdded    = "ADDED"
+	eventModified = "MODIFIED"
+	eventDeleted  = "DELETED"
+	eventBookmark = "BOOKMARK"
+	eventError    = "ERROR"
+)
+
+// how long the API server should keep a single watch open
+const watchTimeoutSeconds = 300
+
+// maximum number of configmaps to request in a single list call
+const listChunkSize = 500
+
 // ObjectReference contains enough information to let you inspect or modify the referred object.
 type ObjectReference struct {
 	Kind            string `json:"kind,omitempty"`
@@ -62,8 +91,25 @@ type Event struct {
 	InvolvedObject *ObjectReference `json:"involvedObject"`
 }
 
-type ConfigMapList struct {
-	Items []ConfigMap `json:"items"`
+// Status is returned by the API server for failed requests and in watch
+// error events.
+type Status struct {
+	Kind    string `json:"kind"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Code    int    `json:"code"`
+}
+
+// WatchEvent is a single event read from a watch stream.
+type WatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type ListMetadata struct {
+	ResourceVersion string `json:"resourceVersion"`
+	Continue        string `json:"continue"`
 }
 
 type ConfigMap struct {
@@ -73,6 +119,15 @@ type ConfigMap struct {
 	Metadata   Metadata          `json:"metadata"`
 }
 
+// Secret holds its data base64 encoded, which encoding/json handles for []byte.
+type Secret struct {
+	ApiVersion string            `json:"apiVersion"`
+	Data       map[string][]byte `json:"data"`
+	Kind       string            `json:"kind"`
+	Metadata   Metadata          `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+}
+
 type Metadata struct {
 	Name            string            `json:"name"`
 	GenerateName    string            `json:"generateName,omitempty"`
@@ -86,6 +141,8 @@ type Metadata struct {
 type k8sClient struct {
 	endpoint string
 	client   *http.Client
+	// namespace the controller is running in, if known
+	namespace string
 }
 
 func newk8sClient(endpoint string) *k8sClient {
@@ -98,35 +155,194 @@ func newk8sClient(endpoint string) *k8sClient {
 	}
 }
 
-func (k *k8sClient) getConfigMaps(namespace, selector string) (*ConfigMapList, error) {
-	path := "/api/v1/configmaps"
+func configMapsPath(namespace string) string {
 	if namespace != "" {
-		path = "/api/v1/namespaces/" + namespace + "/configmaps"
+		return "/api/v1/namespaces/" + namespace + "/configmaps"
 	}
-	if selector != "" {
-		path = path + "?labelSelector=" + selector
+	return "/api/v1/configmaps"
+}
+
+// listConfigMaps calls f for each configmap in namespace. The list is requested in chunks
+// and decoded one item at a time so memory use does not depend on the number of configmaps.
+// It returns the resource version of the list, which may be used to start a watch.
+// ErrGone is returned if a continue token expires; the caller should start over.
+func (k *k8sClient) listConfigMaps(namespace, selector string, f func(*ConfigMap)) (string, error) {
+	var resourceVersion, continueToken string
+	for {
+		v := url.Values{}
+		v.Set("limit", strconv.Itoa(listChunkSize))
+		if selector != "" {
+			v.Set("labelSelector", selector)
+		}
+		if continueToken != "" {
+			v.Set("continue", continueToken)
+		}
+
+		resp, err := k.client.Get(k.endpoint + configMapsPath(namespace) + "?" + v.Encode())
+		if err != nil {
+			return "", err
+		}
+
+		if resp.StatusCode == http.StatusGone {
+			resp.Body.Close()
+			return "", ErrGone
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return "", errors.New("non 200 response code")
+		}
+
+		meta, err := decodeConfigMapList(resp.Body, f)
+		resp.Body.Close()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to decode configmap list")
+		}
+
+		// every chunk is from the same snapshot as the first
+		if resourceVersion == "" {
+			resourceVersion = meta.ResourceVersion
+		}
+		if meta.Continue == "" {
+			return resourceVersion, nil
+		}
+		continueToken = meta.Continue
 	}
+}
 
-	resp, err := k.client.Get(k.endpoint + path)
-	if err != nil {
+// decodeConfigMapList reads a ConfigMapList from r, calling f for each item as it is decoded.
+func decodeConfigMapList(r io.Reader, f func(*ConfigMap)) (*ListMetadata, error) {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, errors.New("non 200 response code")
+
+	var meta ListMetadata
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case "metadata":
+			if err := decoder.Decode(&meta); err != nil {
+				return nil, err
+			}
+		case "items":
+			t, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			// an empty list may be sent as null
+			if t == nil {
+				continue
+			}
+			if d, ok := t.(json.Delim); !ok || d != '[' {
+				return nil, fmt.Errorf("expected items to be a list, got %v", t)
+			}
+			for decoder.More() {
+				var cm ConfigMap
+				if err := decoder.Decode(&cm); err != nil {
+					return nil, err
+				}
+				f(&cm)
+			}
+			if err := expectDelim(decoder, ']'); err != nil {
+				return nil, err
+			}
+		default:
+			var skip json.RawMessage
+			if err := decoder.Decode(&skip); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if err := expectDelim(decoder, '}'); err != nil {
 		return nil, err
 	}
-	resp.Body.Close()
+	return &meta, nil
+}
 
-	var cl ConfigMapList
-	err = json.Unmarshal(data, &cl)
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	t, err := decoder.Token()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("expected %v, got %v", delim, t)
+	}
+	return nil
+}
+
+// watchConfigMaps watches configmaps in namespace, starting at resourceVersion, and
+// calls f for each added, modified, or deleted configmap. It returns when the
+// server closes the watch or ctx is canceled, along with the last resource version
+// seen so the caller may resume. ErrGone is returned if resourceVersion has expired.
+func (k *k8sClient) watchConfigMaps(ctx context.Context, namespace, selector, resourceVersion string, f func(string, *ConfigMap)) (string, error) {
+	v := url.Values{}
+	v.Set("watch", "true")
+	v.Set("allowWatchBookmarks", "true")
+	v.Set("timeoutSeconds", fmt.Sprintf("%d", watchTimeoutSeconds))
+	if resourceVersion != "" {
+		v.Set("resourceVersion", resourceVersion)
+	}
+	if selector != "" {
+		v.Set("labelSelector", selector)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, k.endpoint+configMapsPath(namespace)+"?"+v.Encode(), nil)
+	if err != nil {
+		return resourceVersion, errors.Wrap(err, "failed to create watch request")
+	}
+
+	resp, err := k.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return resourceVersion, errors.Wrap(err, "failed to start watch")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return resourceVersion, ErrGone
+	}
+	if resp.StatusCode != 200 {
+		return resourceVersion, fmt.Errorf("error watching configmaps; got HTTP %v status code", resp.StatusCode)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var e WatchEvent
+		if err := decoder.Decode(&e); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return resourceVersion, nil
+			}
+			return resourceVersion, errors.Wrap(err, "failed to decode watch event")
+		}
+
+		switch e.Type {
+		case eventError:
+			var s Status
+			if err := json.Unmarshal(e.Object, &s); err != nil {
+				return resourceVersion, errors.Wrap(err, "failed to decode watch error")
+			}
+			if s.Code == http.StatusGone {
+				return resourceVersion, ErrGone
+			}
+			return resourceVersion, fmt.Errorf("watch error: %s", s.Message)
+
+		case eventBookmark, eventAdded, eventModified, eventDeleted:
+			var cm ConfigMap
+			if err := json.Unmarshal(e.Object, &cm); err != nil {
+				return resourceVersion, errors.Wrap(err, "failed to decode watch object")
+			}
+			if cm.Metadata.ResourceVersion != "" {
+				resourceVersion = cm.Metadata.ResourceVersion
+			}
+			if e.Type != eventBookmark {
+				f(e.Type, &cm)
+			}
+		}
 	}
-	return &cl, nil
 }
 
 func newConfigMap(namespace, name string) *ConfigMap {
@@ -144,9 +360,49 @@ func newConfigMap(namespace, name string) *ConfigMap {
 	return c
 }
 
-func (k *k8sClient) getConfigMap(namespace, name string) (*ConfigMap, error) {
+// copyConfigMap returns a copy of c that can be modified without changing c.
+func copyConfigMap(c *ConfigMap) *ConfigMap {
+	n := newConfigMap(c.Metadata.Namespace, c.Metadata.Name)
+	n.ApiVersion = c.ApiVersion
+	n.Kind = c.Kind
+	n.Metadata.GenerateName = c.Metadata.GenerateName
+	n.Metadata.ResourceVersion = c.Metadata.ResourceVersion
+	n.Metadata.UID = c.Metadata.UID
+	for k, v := range c.Data {
+		n.Data[k] = v
+	}
+	for k, v := range c.Metadata.Labels {
+		n.Metadata.Labels[k] = v
+	}
+	for k, v := range c.Metadata.Annotations {
+		n.Metadata.Annotations[k] = v
+	}
+	return n
+}
+
+func (c *ConfigMap) objectReference() *ObjectReference {
+	// items in a list do not have kind and apiVersion set
+	kind, apiVersion := c.Kind, c.ApiVersion
+	if kind == "" {
+		kind, apiVersion = "ConfigMap", "v1"
+	}
+	return &ObjectReference{
+		Kind:       kind,
+		APIVersion: apiVersion,
+		Name:       c.Metadata.Name,
+		Namespace:  c.Metadata.Namespace,
+		UID:        c.Metadata.UID,
+	}
+}
+
+func (k *k8sClient) getConfigMap(ctx context.Context, namespace, name string) (*ConfigMap, error) {
 	u := fmt.Sprintf("%s/api/v1/namespaces/%s/configmaps/%s", k.endpoint, namespace, name)
-	resp, err := k.client.Get(u)
+	request, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.client.Do(request.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -163,18 +419,28 @@ func (k *k8sClient) getConfigMap(namespace, name string) (*ConfigMap, error) {
 	return configMapFromReader(resp.Body)
 }
 
-func (k *k8sClient) createConfigMap(c *ConfigMap) (*ConfigMap, error) {
+func (k *k8sClient) createConfigMap(ctx context.Context, c *ConfigMap) (*ConfigMap, error) {
 	body, err := json.MarshalIndent(&c, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("error encoding configmap %s: %v", c.Metadata.Name, err)
 	}
 	u := fmt.Sprintf("%s/api/v1/namespaces/%s/configmaps", k.endpoint, c.Metadata.Namespace)
-	resp, err := http.Post(u, "", bytes.NewReader(body))
+	request, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating configmap %s: %v", c.Metadata.Name, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(request.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error creating configmap %s: %v", c.Metadata.Name, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrAlreadyExists
+	}
+
 	if resp.StatusCode != 201 {
 		return nil, fmt.Errorf("error creating configmap %s; got HTTP %v status code", c.Metadata.Name, resp.StatusCode)
 	}
@@ -182,7 +448,7 @@ func (k *k8sClient) createConfigMap(c *ConfigMap) (*ConfigMap, error) {
 	return configMapFromReader(resp.Body)
 }
 
-func (k *k8sClient) updateConfigMap(c *ConfigMap) (*ConfigMap, error) {
+func (k *k8sClient) updateConfigMap(ctx context.Context, c *ConfigMap) (*ConfigMap, error) {
 	body, err := json.MarshalIndent(&c, "", "  ")
 	if err != nil {
 		return nil, fmt.Errorf("error encoding configmap %s: %v", c.Metadata.Name, err)
@@ -194,12 +460,16 @@ func (k *k8sClient) updateConfigMap(c *ConfigMap) (*ConfigMap, error) {
 		return nil, fmt.Errorf("error updating configmap %s: %v", c.Metadata.Name, err)
 	}
 
-	resp, err := k.client.Do(request)
+	resp, err := k.client.Do(request.WithContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("error updating configmap %s: %v", c.Metadata.Name, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrConflict
+	}
+
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("error updating configmap %s; got HTTP %v status code", c.Metadata.Name, resp.StatusCode)
 	}
@@ -207,6 +477,175 @@ func (k *k8sClient) updateConfigMap(c *ConfigMap) (*ConfigMap, error) {
 	return configMapFromReader(resp.Body)
 }
 
+func newSecret(namespace, name string) *Secret {
+	s := &Secret{
+		ApiVersion: "v1",
+		Data:       make(map[string][]byte),
+		Kind:       "Secret",
+		Type:       "Opaque",
+		Metadata: Metadata{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      make(map[string]string),
+			Annotations: make(map[string]string),
+		},
+	}
+	return s
+}
+
+// copySecret returns a copy of s that can be modified without changing s.
+func copySecret(s *Secret) *Secret {
+	n := newSecret(s.Metadata.Namespace, s.Metadata.Name)
+	n.Type = s.Type
+	n.Metadata.ResourceVersion = s.Metadata.ResourceVersion
+	n.Metadata.UID = s.Metadata.UID
+	for k, v := range s.Data {
+		n.Data[k] = v
+	}
+	for k, v := range s.Metadata.Labels {
+		n.Metadata.Labels[k] = v
+	}
+	for k, v := range s.Metadata.Annotations {
+		n.Metadata.Annotations[k] = v
+	}
+	return n
+}
+
+func (s *Secret) objectReference() *ObjectReference {
+	return &ObjectReference{
+		Kind:       s.Kind,
+		APIVersion: s.ApiVersion,
+		Name:       s.Metadata.Name,
+		Namespace:  s.Metadata.Namespace,
+		UID:        s.Metadata.UID,
+	}
+}
+
+func (k *k8sClient) getSecret(ctx context.Context, namespace, name string) (*Secret, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", k.endpoint, namespace, name)
+	request, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, ErrNotExist
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New("non 200 response code")
+	}
+
+	return secretFromReader(resp.Body)
+}
+
+func (k *k8sClient) createSecret(ctx context.Context, s *Secret) (*Secret, error) {
+	body, err := json.MarshalIndent(&s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding secret %s: %v", s.Metadata.Name, err)
+	}
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", k.endpoint, s.Metadata.Namespace)
+	request, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating secret %s: %v", s.Metadata.Name, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error creating secret %s: %v", s.Metadata.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrAlreadyExists
+	}
+
+	if resp.StatusCode != 201 {
+		return nil, fmt.Errorf("error creating secret %s; got HTTP %v status code", s.Metadata.Name, resp.StatusCode)
+	}
+
+	return secretFromReader(resp.Body)
+}
+
+func (k *k8sClient) updateSecret(ctx context.Context, s *Secret) (*Secret, error) {
+	body, err := json.MarshalIndent(&s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error encoding secret %s: %v", s.Metadata.Name, err)
+	}
+
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", k.endpoint, s.Metadata.Namespace, s.Metadata.Name)
+	request, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error updating secret %s: %v", s.Metadata.Name, err)
+	}
+
+	resp, err := k.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error updating secret %s: %v", s.Metadata.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return nil, ErrConflict
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error updating secret %s; got HTTP %v status code", s.Metadata.Name, resp.StatusCode)
+	}
+
+	return secretFromReader(resp.Body)
+}
+
+func secretFromReader(body io.Reader) (*Secret, error) {
+	var s Secret
+	if err := json.NewDecoder(body).Decode(&s); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal")
+	}
+	return &s, nil
+}
+
+// patchConfigMapAnnotations sets annotations on a configmap, leaving the rest of it unchanged.
+func (k *k8sClient) patchConfigMapAnnotations(namespace, name string, annotations map[string]string) error {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	}
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("error encoding patch for configmap %s: %v", name, err)
+	}
+
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/configmaps/%s", k.endpoint, namespace, name)
+	request, err := http.NewRequest(http.MethodPatch, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error patching configmap %s: %v", name, err)
+	}
+	request.Header.Set("Content-Type", "application/merge-patch+json")
+
+	resp, err := k.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("error patching configmap %s: %v", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return ErrNotExist
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("error patching configmap %s; got HTTP %v status code", name, resp.StatusCode)
+	}
+	return nil
+}
+
 func configMapFromReader(body io.Reader) (*ConfigMap, error) {
 	data, err := ioutil.ReadAll(body)
 	if err != nil {
@@ -228,7 +667,7 @@ func (k *k8sClient) waitForKubernetes() error {
 		case <-timeout:
 			return errors.New("timed out waiting for Kubernetes")
 		case <-tick:
-			resp, err := http.Get(k.endpoint + "/api")
+			resp, err := k.client.Get(k.endpoint + "/api")
 			if err == nil {
 				resp.Body.Close()
 				return nil
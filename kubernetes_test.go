@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeConfigMapList(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		names []string
+		meta  ListMetadata
+		err   bool
+	}{
+		{
+			name:  "items and metadata",
+			body:  `{"kind":"ConfigMapList","metadata":{"resourceVersion":"10","continue":"abc"},"items":[{"metadata":{"name":"a"}},{"metadata":{"name":"b"}}]}`,
+			names: []string{"a", "b"},
+			meta:  ListMetadata{ResourceVersion: "10", Continue: "abc"},
+		},
+		{
+			name:  "metadata after items",
+			body:  `{"items":[{"metadata":{"name":"a"},"data":{"spec":"x"}}],"metadata":{"resourceVersion":"7"}}`,
+			names: []string{"a"},
+			meta:  ListMetadata{ResourceVersion: "7"},
+		},
+		{
+			name: "null items",
+			body: `{"metadata":{"resourceVersion":"3"},"items":null}`,
+			meta: ListMetadata{ResourceVersion: "3"},
+		},
+		{
+			name: "empty items",
+			body: `{"items":[]}`,
+		},
+		{
+			name:  "unknown fields are skipped",
+			body:  `{"apiVersion":"v1","extra":{"nested":[1,2,{"a":"b"}]},"items":[{"metadata":{"name":"a"}}]}`,
+			names: []string{"a"},
+		},
+		{
+			name: "items is not a list",
+			body: `{"items":{"metadata":{"name":"a"}}}`,
+			err:  true,
+		},
+		{
+			name: "not an object",
+			body: `[]`,
+			err:  true,
+		},
+		{
+			name: "truncated",
+			body: `{"items":[{"metadata":{"name":"a"}}`,
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			meta, err := decodeConfigMapList(strings.NewReader(tt.body), func(cm *ConfigMap) {
+				names = append(names, cm.Metadata.Name)
+			})
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(names, tt.names) {
+				t.Errorf("got items %v, want %v", names, tt.names)
+			}
+			if *meta != tt.meta {
+				t.Errorf("got metadata %+v, want %+v", *meta, tt.meta)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// microTimeFormat is the format the API server uses for Lease times.
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// MicroTime is a time with microsecond precision as used by Leases.
+type MicroTime struct {
+	time.Time
+}
+
+func (t MicroTime) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format(microTimeFormat))
+}
+
+func (t *MicroTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		t.Time = time.Time{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	pt, err := time.Parse(microTimeFormat, s)
+	if err != nil {
+		// some servers drop the fractional seconds
+		pt, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+	}
+	t.Time = pt
+	return nil
+}
+
+// LeaseSpec is the specification of a Lease.
+type LeaseSpec struct {
+	HolderIdentity       string     `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int        `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int        `json:"leaseTransitions"`
+}
+
+// Lease is used for leader election between controller replicas.
+type Lease struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Metadata   Metadata  `json:"metadata"`
+	Spec       LeaseSpec `json:"spec"`
+}
+
+func newLease(namespace, name string) *Lease {
+	return &Lease{
+		APIVersion: "coordination.k8s.io/v1",
+		Kind:       "Lease",
+		Metadata: Metadata{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      make(map[string]string),
+			Annotations: make(map[string]string),
+		},
+	}
+}
+
+func (k *k8sClient) getLease(ctx context.Context, namespace, name string) (*Lease, error) {
+	u := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", k.endpoint, namespace, name)
+	request, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, ErrNotExist
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error getting lease %s; got HTTP %v status code", name, resp.StatusCode)
+	}
+
+	var l Lease
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal lease")
+	}
+	return &l, nil
+}
+
+func (k *k8sClient) createLease(ctx context.Context, l *Lease) error {
+	body, err := json.MarshalIndent(&l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding lease %s: %v", l.Metadata.Name, err)
+	}
+
+	u := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", k.endpoint, l.Metadata.Namespace)
+	request, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating lease %s: %v", l.Metadata.Name, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error creating lease %s: %v", l.Metadata.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return ErrAlreadyExists
+	}
+
+	if resp.StatusCode != 201 {
+		return fmt.Errorf("error creating lease %s; got HTTP %v status code", l.Metadata.Name, resp.StatusCode)
+	}
+	return nil
+}
+
+func (k *k8sClient) updateLease(ctx context.Context, l *Lease) error {
+	body, err := json.MarshalIndent(&l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding lease %s: %v", l.Metadata.Name, err)
+	}
+
+	u := fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", k.endpoint, l.Metadata.Namespace, l.Metadata.Name)
+	request, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error updating lease %s: %v", l.Metadata.Name, err)
+	}
+
+	resp, err := k.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error updating lease %s: %v", l.Metadata.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return ErrConflict
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("error updating lease %s; got HTTP %v status code", l.Metadata.Name, resp.StatusCode)
+	}
+	return nil
+}
+
+// leaderElector uses a Lease to ensure only one replica is processing at a time.
+type leaderElector struct {
+	client    *k8sClient
+	namespace string
+	name      string
+	identity  string
+	// how long a lease is valid without being renewed
+	leaseDuration time.Duration
+	// how long the leader keeps trying to renew before giving up leadership
+	renewDeadline time.Duration
+	// how often to try to acquire or renew the lease
+	retryPeriod time.Duration
+
+	// the last lease spec seen and when we saw it. We use our own clock
+	// rather than the times in the lease to avoid depending on clock skew.
+	observedSpec LeaseSpec
+	observedTime time.Time
+}
+
+// run calls lead while this replica holds the lease. The context passed to lead
+// is canceled when leadership is lost. run returns when ctx is canceled.
+func (l *leaderElector) run(ctx context.Context, lead func(context.Context)) {
+	for {
+		if !l.acquire(ctx) {
+			return
+		}
+		log.Printf("%s became leader using lease %s/%s", l.identity, l.namespace, l.name)
+
+		leadCtx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lead(leadCtx)
+		}()
+
+		l.renew(leadCtx)
+		cancel()
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			l.release()
+			return
+		}
+		log.Printf("%s lost leadership of lease %s/%s", l.identity, l.namespace, l.name)
+	}
+}
+
+// acquire blocks until the lease is acquired. It returns false if ctx is canceled first.
+func (l *leaderElector) acquire(ctx context.Context) bool {
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, l.renewDeadline)
+		ok, err := l.tryAcquireOrRenew(attemptCtx)
+		cancel()
+		if err != nil {
+			log.Printf("failed to acquire lease %s/%s: %v", l.namespace, l.name, err)
+		}
+		if ok {
+			return true
+		}
+		if !sleepContext(ctx, l.retryPeriod) {
+			return false
+		}
+	}
+}
+
+// renew keeps renewing the lease until it fails to do so within renewDeadline
+// or ctx is canceled. Each attempt must finish by the deadline, so a request that
+// hangs can not keep this replica acting as leader after its lease may have expired.
+func (l *leaderElector) renew(ctx context.Context) {
+	deadline := time.Now().Add(l.renewDeadline)
+	for {
+		if !sleepContext(ctx, l.retryPeriod) {
+			return
+		}
+		attemptCtx, cancel := context.WithDeadline(ctx, deadline)
+		ok, err := l.tryAcquireOrRenew(attemptCtx)
+		cancel()
+		if err != nil {
+			log.Printf("failed to renew lease %s/%s: %v", l.namespace, l.name, err)
+		}
+		if ok {
+			deadline = time.Now().Add(l.renewDeadline)
+			continue
+		}
+		if !time.Now().Before(deadline) {
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew returns true if this replica holds the lease.
+func (l *leaderElector) tryAcquireOrRenew(ctx context.Context) (bool, error) {
+	now := time.Now()
+
+	lease, err := l.client.getLease(ctx, l.namespace, l.name)
+	if err == ErrNotExist {
+		lease = newLease(l.namespace, l.name)
+		lease.Spec = LeaseSpec{
+			HolderIdentity:       l.identity,
+			LeaseDurationSeconds: int(l.leaseDuration.Seconds()),
+			AcquireTime:          &MicroTime{now},
+			RenewTime:            &MicroTime{now},
+		}
+		if err := l.client.createLease(ctx, lease); err != nil {
+			if err == ErrAlreadyExists {
+				return false, nil
+			}
+			return false, err
+		}
+		l.observe(lease.Spec, now)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !sameLeaseSpec(lease.Spec, l.observedSpec) {
+		l.observe(lease.Spec, now)
+	}
+
+	holder := lease.Spec.HolderIdentity
+	if holder != "" && holder != l.identity && l.observedTime.Add(l.leaseDuration).After(now) {
+		return false, nil
+	}
+
+	if holder != l.identity {
+		lease.Spec.HolderIdentity = l.identity
+		lease.Spec.AcquireTime = &MicroTime{now}
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(l.leaseDuration.Seconds())
+	lease.Spec.RenewTime = &MicroTime{now}
+
+	if err := l.client.updateLease(ctx, lease); err != nil {
+		if err == ErrConflict {
+			return false, nil
+		}
+		return false, err
+	}
+	l.observe(lease.Spec, now)
+	return true, nil
+}
+
+// release gives up the lease so another replica can take over without waiting for it to expire.
+func (l *leaderElector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.renewDeadline)
+	defer cancel()
+
+	lease, err := l.client.getLease(ctx, l.namespace, l.name)
+	if err != nil || lease.Spec.HolderIdentity != l.identity {
+		return
+	}
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	if err := l.client.updateLease(ctx, lease); err != nil {
+		log.Printf("failed to release lease %s/%s: %v", l.namespace, l.name, err)
+	}
+}
+
+func (l *leaderElector) observe(spec LeaseSpec, t time.Time) {
+	l.observedSpec = spec
+	l.observedTime = t
+}
+
+func sameLeaseSpec(a, b LeaseSpec) bool {
+	return a.HolderIdentity == b.HolderIdentity &&
+		a.LeaseTransitions == b.LeaseTransitions &&
+		microTimeEqual(a.RenewTime, b.RenewTime)
+}
+
+func microTimeEqual(a, b *MicroTime) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b.Time)
+}
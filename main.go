@@ -2,9 +2,11 @@ package main
 
 //import "gopkg.in/yaml.v2"
 import (
+	"context"
 	"encoding/hex"
 	"hash/fnv"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -25,6 +27,16 @@ const (
 	specAnnotationKey = "spec"
 	configFileKey     = "alertmanager.yml"
 	routeDefaultKey   = "alertmanager-default-route"
+
+	outputConfigMap = "configmap"
+	outputSecret    = "secret"
+	outputFile      = "file"
+
+	// how many times to restart a list whose continue token expired
+	maxListAttempts = 3
+
+	// how many times to retry writing the target when it was modified concurrently
+	maxUpsertAttempts = 5
 )
 
 type (
@@ -34,48 +46,243 @@ type (
 		targetName      string
 		selector        string
 		namespaces      []string
+		syncInterval    time.Duration
+		debounce        time.Duration
+		// how source configmaps are combined
+		options *generateOptions
+		// kind of object the config is written to
+		outputKind string
+		// file output only
+		outputFile    string
+		reloadURLs    []string
+		reloadPending bool
+		// hash of the last generated config that failed validation,
+		// so we only warn about it once
+		heldBackHash string
+		// only the leader processes configmaps when this is set
+		leaderElect bool
+
+		mu sync.Mutex
+		// route tree of the last config written, for route tests
+		route *Route
+		// hash of each source configmap, by namespace/name, and of the last
+		// target configmap written, so unrelated watch events can be ignored
+		sources     map[string]string
+		writtenHash string
 	}
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "alertmanager-config-controller [target-namespace] [target-name]",
 	Short: "Collects alertmanager configs as defined in configmaps and generates a single config",
-	Run:   runController,
+	Long: `Collects alertmanager configs as defined in configmaps and generates a single config. Without a
+subcommand, it reads configmaps from kubernetes and writes the config to the target. The other commands
+read configmap manifests from local files, directories, or globs instead, and the --selector and
+--namespace flags select configmaps from them as they would from the API.`,
+	Run: runController,
 }
 
 var (
-	selector, endpoint string
-	namespaces         []string
-	onetime            bool
-	syncInterval       time.Duration
+	selector, endpoint      string
+	kubeconfig, kubeContext string
+	namespaces              []string
+	outputKind, outputPath  string
+	reloadURLs              []string
+	onetime, inCluster      bool
+	tolerant                bool
+	unknownFieldsMode       string
+	namespaceLabel          string
+	unscopedNamespaces      []string
+	receiverNameFormat      string
+	globalConflicts         string
+	defaultRouteConflicts   string
+	receiverConflicts       string
+	syncInterval            time.Duration
+	debounce                time.Duration
+	listenAddress           string
+
+	leaderElect                               bool
+	leaseNamespace, leaseName, leaseIdentity  string
+	leaseDuration, renewDeadline, retryPeriod time.Duration
 )
 
 func main() {
 	rootCmd.PersistentFlags().StringVarP(&selector, "selector", "s", "", "label selector")
 	rootCmd.PersistentFlags().StringVarP(&endpoint, "endpoint", "e", "http://127.0.0.1:8001", "kubernetes endpoint")
 	rootCmd.PersistentFlags().StringArrayVarP(&namespaces, "namespace", "n", nil, "namespace to query. can be used multiple times. default is all namespaces")
-	rootCmd.PersistentFlags().BoolVarP(&onetime, "onetime", "o", false, "run one time and exit.")
-	rootCmd.PersistentFlags().DurationVarP(&syncInterval, "sync-interval", "i", (60 * time.Second), "the time duration between processing.")
+	rootCmd.PersistentFlags().BoolVarP(&inCluster, "in-cluster", "", false, "use the pod's service account to connect to kubernetes rather than --endpoint.")
+	rootCmd.PersistentFlags().StringVarP(&kubeconfig, "kubeconfig", "", "", "path to a kubeconfig file to use to connect to kubernetes rather than --endpoint.")
+	rootCmd.PersistentFlags().StringVarP(&kubeContext, "context", "", "", "kubeconfig context to use. default is the current context.")
+	rootCmd.PersistentFlags().StringVarP(&outputKind, "output-kind", "", outputConfigMap, "kind of the target object to write the config to. one of configmap, secret, or file.")
+	rootCmd.PersistentFlags().StringVarP(&outputPath, "output-file", "", "", "path to write the config to when --output-kind=file or rendering.")
+	rootCmd.PersistentFlags().BoolVarP(&tolerant, "tolerant", "", false, "skip config maps that fail to parse or validate, and routes that depend on them, rather than failing.")
+	rootCmd.PersistentFlags().StringVarP(&unknownFieldsMode, "unknown-fields", "", unknownFieldsDrop, "what to do with fields in a config map that the controller does not know about. one of drop, reject, or keep.")
+	rootCmd.PersistentFlags().StringVarP(&namespaceLabel, "namespace-label", "", "", "if set, routes only match alerts with this label set to the namespace of their config map.")
+	rootCmd.PersistentFlags().StringArrayVarP(&unscopedNamespaces, "unscoped-namespace", "", nil, "namespace whose routes are not limited by --namespace-label. can be used multiple times.")
+	rootCmd.PersistentFlags().StringVarP(&receiverNameFormat, "receiver-name-format", "", "", "if set, receivers are renamed using this format, such as {namespace}/{name}, and routes are rewritten to match.")
+	rootCmd.PersistentFlags().StringVarP(&globalConflicts, "global-conflicts", "", conflictPolicyError, "what to do when more than one config map defines the global config. one of error, priority, or merge.")
+	rootCmd.PersistentFlags().StringVarP(&defaultRouteConflicts, "default-route-conflicts", "", conflictPolicyError, "what to do when more than one config map defines the default route. one of error or priority.")
+	rootCmd.PersistentFlags().StringVarP(&receiverConflicts, "receiver-conflicts", "", conflictPolicyError, "what to do when more than one config map defines a receiver with the same name. one of error or priority.")
+
+	// flags only used when running the controller, so subcommands reject them
+	rootCmd.Flags().StringArrayVarP(&reloadURLs, "reload-url", "", nil, "alertmanager URL to reload after writing --output-file. can be used multiple times.")
+	rootCmd.Flags().StringVarP(&listenAddress, "listen-address", "", "", "if set, address to serve the route test endpoint on, such as :8080.")
+	rootCmd.Flags().BoolVarP(&onetime, "onetime", "o", false, "run one time and exit.")
+	rootCmd.Flags().DurationVarP(&syncInterval, "sync-interval", "i", (60 * time.Second), "the time duration between full resyncs. changes are normally processed as they are watched.")
+	rootCmd.Flags().DurationVarP(&debounce, "debounce", "d", (2 * time.Second), "how long to wait for further changes before processing a watched change.")
+
+	hostname, _ := os.Hostname()
+	rootCmd.Flags().BoolVarP(&leaderElect, "leader-elect", "", false, "use a lease so only one of multiple replicas processes at a time.")
+	rootCmd.Flags().StringVarP(&leaseNamespace, "leader-elect-namespace", "", "", "namespace of the leader election lease. default is the target namespace.")
+	rootCmd.Flags().StringVarP(&leaseName, "leader-elect-name", "", "", "name of the leader election lease. default is the target name.")
+	rootCmd.Flags().StringVarP(&leaseIdentity, "leader-elect-identity", "", hostname, "identity of this replica in the leader election lease.")
+	rootCmd.Flags().DurationVarP(&leaseDuration, "leader-elect-lease-duration", "", (15 * time.Second), "how long standby replicas wait before taking over an unrenewed lease.")
+	rootCmd.Flags().DurationVarP(&renewDeadline, "leader-elect-renew-deadline", "", (10 * time.Second), "how long the leader retries renewing the lease before giving up leadership.")
+	rootCmd.Flags().DurationVarP(&retryPeriod, "leader-elect-retry-period", "", (2 * time.Second), "how often to try to acquire or renew the lease.")
+
+	validateCmd.Flags().StringVarP(&validateFormat, "format", "", formatText, "output format. one of text or json.")
+	routeTestCmd.Flags().StringArrayVarP(&routeTestLabels, "label", "l", nil, "label of the alert to test, as name=value. can be used multiple times.")
+	routeTestCmd.Flags().StringVarP(&routeTestFormat, "format", "", formatText, "output format. one of text or json.")
+	routeDiffCmd.Flags().StringVarP(&routeDiffAlerts, "alerts", "a", "", "JSON or YAML file of alerts to replay.")
+	routeDiffCmd.Flags().StringVarP(&routeDiffTarget, "target", "", "", "target configmap or secret to compare with, as namespace/name, or a name in the namespace of the client.")
+	routeDiffCmd.Flags().StringVarP(&routeDiffFormat, "format", "", formatText, "output format. one of text or json.")
+	routeCmd.AddCommand(routeTestCmd, routeDiffCmd)
+	inhibitTestCmd.Flags().StringVarP(&inhibitTestAlerts, "alerts", "a", "", "JSON or YAML file of firing alerts.")
+	inhibitTestCmd.Flags().StringVarP(&inhibitTestFormat, "format", "", formatText, "output format. one of text or json.")
+	inhibitCmd.AddCommand(inhibitTestCmd)
+	rootCmd.AddCommand(renderCmd, validateCmd, routeCmd, inhibitCmd)
+
+	// this version of cobra rejects positional arguments on a command with subcommands,
+	// so they are only kept when one is being run. runController checks the arguments
+	// itself. A target namespace with the same name as a subcommand must be given as
+	// namespace/name.
+	if cmd, _, err := rootCmd.Find(os.Args[1:]); err != nil && cmd == rootCmd {
+		rootCmd.RemoveCommand(rootCmd.Commands()...)
+	}
 
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// newClient creates a kubernetes client based on the command line flags.
+func newClient() (*k8sClient, error) {
+	switch {
+	case inCluster && kubeconfig != "":
+		return nil, errors.New("only one of --in-cluster and --kubeconfig may be set")
+	case inCluster:
+		return newInClusterClient()
+	case kubeconfig != "":
+		return newKubeConfigClient(kubeconfig, kubeContext)
+	}
+	return newk8sClient(endpoint), nil
+}
+
+// generateOptionsFromFlags checks the flags that control how configmaps are combined.
+func generateOptionsFromFlags() (*generateOptions, error) {
+	switch unknownFieldsMode {
+	case unknownFieldsDrop, unknownFieldsReject, unknownFieldsKeep:
+	default:
+		return nil, errors.Errorf("unknown value for --unknown-fields %s", unknownFieldsMode)
+	}
+	// names from different namespaces would collide
+	if receiverNameFormat != "" && (!strings.Contains(receiverNameFormat, "{namespace}") || !strings.Contains(receiverNameFormat, "{name}")) {
+		return nil, errors.New("--receiver-name-format must include {namespace} and {name}")
+	}
+	switch globalConflicts {
+	case conflictPolicyError, conflictPolicyPriority, conflictPolicyMerge:
+	default:
+		return nil, errors.Errorf("unknown value for --global-conflicts %s", globalConflicts)
+	}
+	for flag, policy := range map[string]string{"default-route-conflicts": defaultRouteConflicts, "receiver-conflicts": receiverConflicts} {
+		if policy != conflictPolicyError && policy != conflictPolicyPriority {
+			return nil, errors.Errorf("unknown value for --%s %s", flag, policy)
+		}
+	}
+
+	return &generateOptions{
+		Tolerant:           tolerant,
+		UnknownFields:      unknownFieldsMode,
+		NamespaceLabel:     namespaceLabel,
+		UnscopedNamespaces: unscopedNamespaces,
+		ReceiverNameFormat: receiverNameFormat,
+
+		GlobalConflicts:       globalConflicts,
+		DefaultRouteConflicts: defaultRouteConflicts,
+		ReceiverConflicts:     receiverConflicts,
+	}, nil
+}
+
+// parseTarget returns the target namespace and name from the positional arguments. The target
+// is given as a namespace and a name, as namespace/name, or as just a name in namespace,
+// which is the namespace the client is running in, if known.
+func parseTarget(args []string, namespace string) (string, string, error) {
+	switch len(args) {
+	case 0:
+		return "", "", nil
+	case 1:
+		if i := strings.Index(args[0], "/"); i >= 0 {
+			return args[0][:i], args[0][i+1:], nil
+		}
+		if namespace == "" {
+			return "", "", errors.New("namespace of target is required")
+		}
+		return namespace, args[0], nil
+	case 2:
+		return args[0], args[1], nil
+	}
+	return "", "", errors.Errorf("expected at most a target namespace and name, got %d arguments", len(args))
+}
+
 func runController(cmd *cobra.Command, args []string) {
-	if len(args) != 2 {
-		log.Fatal("namespace and name of target configmap is required")
+	client, err := newClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	targetNamespace, targetName, err := parseTarget(args, client.namespace)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch outputKind {
+	case outputConfigMap, outputSecret:
+		if targetNamespace == "" || targetName == "" {
+			log.Fatalf("namespace and name of target %s is required", outputKind)
+		}
+	case outputFile:
+		if outputPath == "" {
+			log.Fatal("--output-file is required when writing to a file")
+		}
+		// there is no target object, but allow one to be named so it is still skipped
+		if (targetNamespace == "") != (targetName == "") {
+			log.Fatal("namespace and name of target configmap must both be set if either is")
+		}
+	default:
+		log.Fatalf("unknown output kind %s", outputKind)
+	}
+
+	options, err := generateOptionsFromFlags()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	if len(namespaces) == 0 {
 		namespaces = append(namespaces, "")
 	}
 	c := &controller{
-		client:          newk8sClient(endpoint),
+		client:          client,
 		selector:        selector,
 		namespaces:      namespaces,
-		targetNamespace: args[0],
-		targetName:      args[1],
+		targetNamespace: targetNamespace,
+		targetName:      targetName,
+		syncInterval:    syncInterval,
+		debounce:        debounce,
+		options:         options,
+		outputKind:      outputKind,
+		outputFile:      outputPath,
+		reloadURLs:      reloadURLs,
+		leaderElect:     leaderElect,
 	}
 
 	log.Println("Starting configmap-aggregator...")
@@ -85,32 +292,54 @@ func runController(cmd *cobra.Command, args []string) {
 	}
 
 	if onetime {
-		if err := c.process(); err != nil {
+		if err := c.process(context.Background()); err != nil {
 			log.Fatal(err)
 		}
 		os.Exit(0)
 	}
 
-	var wg sync.WaitGroup
-	done := make(chan struct{})
+	if leaderElect && outputKind == outputFile {
+		log.Fatal("leader election can not be used when writing to a file")
+	}
+	if leaderElect && (leaseIdentity == "" || renewDeadline >= leaseDuration || retryPeriod >= renewDeadline) {
+		log.Fatal("leader election requires an identity and retry period < renew deadline < lease duration")
+	}
+
+	if listenAddress != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/route/test", c.routeTestHandler)
+		go func() {
+			log.Fatal(http.ListenAndServe(listenAddress, mux))
+		}()
+	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
 	go func() {
-		wg.Add(1)
-		for {
-			if err := c.process(); err != nil {
-				log.Printf("failed to process config maps: %v", err)
-			}
-			// TODO: info level?
-			//else {
-			//	log.Printf("configmap aggregation complete. Next sync in %v seconds.", syncInterval.Seconds())
-			//}
-			select {
-			case <-time.After(syncInterval):
-			case <-done:
-				wg.Done()
-				return
-			}
+		defer wg.Done()
+		if !leaderElect {
+			c.run(ctx)
+			return
 		}
+
+		l := &leaderElector{
+			client:        c.client,
+			namespace:     leaseNamespace,
+			name:          leaseName,
+			identity:      leaseIdentity,
+			leaseDuration: leaseDuration,
+			renewDeadline: renewDeadline,
+			retryPeriod:   retryPeriod,
+		}
+		if l.namespace == "" {
+			l.namespace = c.targetNamespace
+		}
+		if l.name == "" {
+			l.name = c.targetName
+		}
+		l.run(ctx, c.run)
 	}()
 
 	signalChan := make(chan os.Signal, 1)
@@ -118,12 +347,21 @@ func runController(cmd *cobra.Command, args []string) {
 
 	<-signalChan
 	log.Printf("Shutdown signal received, exiting...")
-	close(done)
+	cancel()
 	wg.Wait()
 	os.Exit(0)
 }
 
 func hashConfigMap(cm *ConfigMap) string {
+	// we only hash the data for now
+	return hashData(cm.Data)
+}
+
+func hashSecret(s *Secret) string {
+	return hashData(s.Data)
+}
+
+func hashData(data interface{}) string {
 	h := fnv.New64()
 	printer := spew.ConfigState{
 		Indent:         " ",
@@ -132,8 +370,7 @@ func hashConfigMap(cm *ConfigMap) string {
 		SpewKeys:       true,
 	}
 
-	// we only hash the data for now
-	printer.Fprintf(h, "%#v", cm.Data)
+	printer.Fprintf(h, "%#v", data)
 	return hex.EncodeToString(h.Sum(nil))
 }
 
@@ -142,6 +379,11 @@ func compareConfigMaps(a, b *ConfigMap) bool {
 	return hashConfigMap(a) == hashConfigMap(b)
 }
 
+// true if they are the same
+func compareSecrets(a, b *Secret) bool {
+	return hashSecret(a) == hashSecret(b)
+}
+
 func readObject(cm *ConfigMap, o interface{}) (bool, error) {
 	data := cm.Data[specAnnotationKey]
 	if data == "" {
@@ -150,157 +392,248 @@ func readObject(cm *ConfigMap, o interface{}) (bool, error) {
 	}
 	err := yaml.Unmarshal([]byte(data), o)
 	if err != nil {
-		return false, errors.Wrapf(err, "failed to parse '%s' data for %s/%s", specAnnotationKey, cm.Metadata.Namespace, cm.Metadata.Name)
+		return false, errors.Wrapf(specError{err}, "failed to parse '%s' data for %s/%s", specAnnotationKey, cm.Metadata.Namespace, cm.Metadata.Name)
 	}
 	return true, nil
 }
 
-func (c *controller) process() error {
-	cm, err := c.createConfigMap()
+// specError is returned when the spec of a configmap is not valid YAML for its type.
+// Line numbers in it are counted from the start of the spec.
+type specError struct {
+	error
+}
+
+// specName returns the name set in the spec of cm, even if the rest of it can not be parsed.
+// If the spec is not valid YAML, the first top level name key is used.
+func specName(cm *ConfigMap) string {
+	var o struct {
+		Name string `yaml:"name"`
+	}
+	data := cm.Data[specAnnotationKey]
+	if err := yaml.Unmarshal([]byte(data), &o); err == nil {
+		return o.Name
+	}
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "name:") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "name:")), `'"`)
+		}
+	}
+	return ""
+}
+
+// specKeys returns the top level keys set in the spec of cm.
+func specKeys(cm *ConfigMap) (map[string]bool, error) {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal([]byte(cm.Data[specAnnotationKey]), &m); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse '%s' data for %s/%s", specAnnotationKey, cm.Metadata.Namespace, cm.Metadata.Name)
+	}
+	keys := make(map[string]bool, len(m))
+	for k := range m {
+		keys[k] = true
+	}
+	return keys, nil
+}
+
+// process generates the config and writes it to the target. Nothing is written once ctx is
+// canceled, as another replica may have become leader.
+func (c *controller) process(ctx context.Context) error {
+	cfg, cm, results, err := c.createConfigMap()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
+		c.reportStatus(results, false)
 		return err
 	}
-	return c.upsertConfigMap(cm)
+	if err := rejectionReport(results); err != nil {
+		log.Printf("generated config without some config maps: %v", err)
+	}
+	if err := c.checkConfig(ctx, cm); err != nil {
+		c.reportStatus(results, false)
+		return err
+	}
+
+	if err := c.write(ctx, cm); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.reportStatus(results, false)
+		return err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	c.reportStatus(results, true)
+
+	c.mu.Lock()
+	c.route = cfg.Route
+	c.writtenHash = hashConfigMap(cm)
+	c.mu.Unlock()
+	return nil
 }
 
-func (c *controller) createConfigMap() (*ConfigMap, error) {
-	cfg := Config{}
+// write writes the generated config map to the target.
+func (c *controller) write(ctx context.Context, cm *ConfigMap) error {
+	switch c.outputKind {
+	case outputSecret:
+		return c.upsertSecret(ctx, cm)
+	case outputFile:
+		return c.writeFile(cm)
+	}
+	return c.upsertConfigMap(ctx, cm)
+}
 
-	var routes []*Route
-	var defaultRoute *Route
+// listConfigMaps returns the configmaps in namespace that may be part of the config,
+// along with the resource version of the list. Other configmaps are discarded as they
+// are read. The list is restarted if it takes long enough for its continue token to expire.
+func (c *controller) listConfigMaps(namespace string) ([]*ConfigMap, string, error) {
+	for i := 1; ; i++ {
+		var items []*ConfigMap
+		rv, err := c.client.listConfigMaps(namespace, c.selector, func(cm *ConfigMap) {
+			if cm.Metadata.Annotations[typeAnnotationKey] != "" {
+				items = append(items, cm)
+			}
+		})
+		if err == ErrGone && i < maxListAttempts {
+			log.Printf("list of config maps for %s %s expired, restarting", namespace, c.selector)
+			continue
+		}
+		return items, rv, err
+	}
+}
 
+// createConfigMap generates the config from the source configmaps. The returned results
+// record which source configmaps were used and why any were rejected.
+func (c *controller) createConfigMap() (*Config, *ConfigMap, []*fragmentResult, error) {
+	var items []*ConfigMap
 	for _, n := range c.namespaces {
-		list, err := c.client.getConfigMaps(n, selector)
+		list, _, err := c.listConfigMaps(n)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to get config maps for %s %s", n, c.selector)
+			return nil, nil, nil, errors.Wrapf(err, "failed to get config maps for %s %s", n, c.selector)
 		}
 
-	ITEMS:
-		for i := range list.Items {
-			cm := &list.Items[i]
+		for _, cm := range list {
 			if cm.Metadata.Namespace == c.targetNamespace && cm.Metadata.Name == c.targetName {
-				continue ITEMS
-			}
-
-			// XXX: this is clumsy
-			switch strings.ToLower(cm.Metadata.Annotations[typeAnnotationKey]) {
-			case "global":
-				var g GlobalConfig
-				rc, err := readObject(cm, &g)
-				if err != nil {
-					return nil, err
-				}
-
-				if rc {
-					cfg.Global = &g
-				}
-			case "inhibitrule":
-				var r InhibitRule
-				rc, err := readObject(cm, &r)
-				if err != nil {
-					return nil, err
-				}
-				if rc {
-					cfg.InhibitRules = append(cfg.InhibitRules, &r)
-				}
-			case "receiver":
-				var r Receiver
-				rc, err := readObject(cm, &r)
-				if err != nil {
-					return nil, err
-				}
-				if rc {
-					cfg.Receivers = append(cfg.Receivers, &r)
-				}
-
-			case "template":
-				var t string
-				rc, err := readObject(cm, &t)
-				if err != nil {
-					return nil, err
-				}
-
-				if rc && t != "" {
-					cfg.Templates = append(cfg.Templates, t)
-				}
-
-			case "route":
-				var r Route
-				rc, err := readObject(cm, &r)
-				if err != nil {
-					return nil, err
-				}
-
-				if rc {
-					if len(r.Routes) > 0 {
-						log.Printf("route %s/%s has child routes defined, they will be ignored", cm.Metadata.Namespace, cm.Metadata.Name)
-					}
-					if cm.Metadata.Annotations[routeDefaultKey] == "true" {
-						if defaultRoute != nil {
-							log.Printf("default route already set by %s/%s sets it again", cm.Metadata.Namespace, cm.Metadata.Name)
-						}
-						defaultRoute = &r
-					} else {
-						routes = append(routes, &r)
-					}
-				}
+				continue
 			}
+			items = append(items, cm)
 		}
 	}
+	c.rememberSources(items)
 
-	if defaultRoute == nil {
-		return nil, errors.New("no default route found")
+	cfg, results, err := generateConfig(items, c.options)
+	if err != nil {
+		return nil, nil, results, err
 	}
 
-	defaultRoute.Routes = routes
-	cfg.Route = defaultRoute
-
 	cm := newConfigMap(c.targetNamespace, c.targetName)
 
 	data, err := yaml.Marshal(cfg)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal config")
+		return nil, nil, results, errors.Wrap(err, "failed to marshal config")
 	}
 	cm.Data[configFileKey] = string(data)
 
-	return cm, nil
+	return cfg, cm, results, nil
 }
 
-func (c *controller) postEvent(reason string, cm *ConfigMap) error {
+func (c *controller) postEvent(reason string, o *ObjectReference) error {
+	msg := reason + " " + strings.ToLower(o.Kind)
+	return c.sendEvent("Normal", msg, msg, o)
+}
+
+// postWarning posts a Warning event about o.
+func (c *controller) postWarning(reason, message string, o *ObjectReference) error {
+	return c.sendEvent("Warning", reason, message, o)
+}
 
-	e := newEvent(cm.Metadata.Namespace, cm.Metadata.Name+"-")
+func (c *controller) sendEvent(eventType, reason, message string, o *ObjectReference) error {
+
+	e := newEvent(o.Namespace, o.Name+"-")
 
-	msg := reason + " configmap"
 	now := time.Now()
 
 	e.Count = 1
-	e.Message = msg
-	e.Reason = msg
+	e.Message = message
+	e.Reason = reason
 	e.LastTimestamp = now
 	e.FirstTimestamp = now
 	e.Source.Component = "alertmanager-config-controller"
-	e.Type = "Normal"
+	e.Type = eventType
 	// how would we get host and do we even care?
 
-	e.InvolvedObject = &ObjectReference{
-		Kind:       cm.Kind,
-		APIVersion: cm.ApiVersion,
-		Name:       cm.Metadata.Name,
-		Namespace:  cm.Metadata.Namespace,
-		//ResourceVersion: cm.Metadata.ResourceVersion,
-		UID: cm.Metadata.UID,
-	}
+	e.InvolvedObject = o
 	return c.client.postEvent(e)
 }
 
-func (c *controller) upsertConfigMap(cm *ConfigMap) error {
-	existing, err := c.client.getConfigMap(c.targetNamespace, c.targetName)
+// checkConfig validates the generated config the way Alertmanager does when loading it.
+// If it is invalid, the target is left with its last known good content and a
+// Warning event explains why.
+func (c *controller) checkConfig(ctx context.Context, cm *ConfigMap) error {
+	_, err := loadConfig(cm.Data[configFileKey])
+	if err == nil {
+		c.heldBackHash = ""
+		return nil
+	}
+
+	err = errors.Wrap(err, "generated config is invalid, keeping the last known good config")
+	hash := hashConfigMap(cm)
+	if hash == c.heldBackHash {
+		return err
+	}
+	c.heldBackHash = hash
+
+	var o *ObjectReference
+	switch c.outputKind {
+	case outputConfigMap:
+		if existing, gerr := c.client.getConfigMap(ctx, c.targetNamespace, c.targetName); gerr == nil {
+			o = existing.objectReference()
+		}
+	case outputSecret:
+		if existing, gerr := c.client.getSecret(ctx, c.targetNamespace, c.targetName); gerr == nil {
+			o = existing.objectReference()
+		}
+	}
+	if o != nil {
+		if perr := c.postWarning("InvalidConfig", err.Error(), o); perr != nil {
+			log.Printf("failed to post event for %s/%s: %v", c.targetNamespace, c.targetName, perr)
+		}
+	}
+	return err
+}
+
+// retryOnConflict calls f until it does not return ErrConflict or ErrAlreadyExists,
+// or the maximum number of attempts is reached, or ctx is canceled.
+func (c *controller) retryOnConflict(ctx context.Context, f func() error) error {
+	for i := 1; i <= maxUpsertAttempts; i++ {
+		err := f()
+		if err != ErrConflict && err != ErrAlreadyExists {
+			return err
+		}
+		log.Printf("%s/%s was modified concurrently (%v), retrying", c.targetNamespace, c.targetName, err)
+		if !sleepContext(ctx, time.Duration(i)*100*time.Millisecond) {
+			return ctx.Err()
+		}
+	}
+	return errors.Errorf("failed to write %s/%s after %d attempts", c.targetNamespace, c.targetName, maxUpsertAttempts)
+}
+
+func (c *controller) upsertConfigMap(ctx context.Context, cm *ConfigMap) error {
+	return c.retryOnConflict(ctx, func() error {
+		return c.tryUpsertConfigMap(ctx, copyConfigMap(cm))
+	})
+}
+
+// tryUpsertConfigMap reads the target and creates or updates it. ErrConflict or ErrAlreadyExists
+// is returned if the target changed between the read and the write.
+func (c *controller) tryUpsertConfigMap(ctx context.Context, cm *ConfigMap) error {
+	existing, err := c.client.getConfigMap(ctx, c.targetNamespace, c.targetName)
 	if err == ErrNotExist {
-		n, err := c.client.createConfigMap(cm)
+		n, err := c.client.createConfigMap(ctx, cm)
 		if err != nil {
 			return err
 		}
-		return c.postEvent("creating", n)
+		return c.postEvent("creating", n.objectReference())
 	}
 	if err != nil {
 		return errors.Wrapf(err, "failed to get config map %s/%s", c.targetNamespace, c.targetName)
@@ -321,9 +654,55 @@ func (c *controller) upsertConfigMap(cm *ConfigMap) error {
 	if compareConfigMaps(existing, cm) {
 		return nil
 	}
-	n, err := c.client.updateConfigMap(cm)
+	n, err := c.client.updateConfigMap(ctx, cm)
+	if err != nil {
+		return err
+	}
+	return c.postEvent("updating", n.objectReference())
+}
+
+// upsertSecret writes the data of the generated config map to the target secret.
+func (c *controller) upsertSecret(ctx context.Context, cm *ConfigMap) error {
+	s := newSecret(c.targetNamespace, c.targetName)
+	for k, v := range cm.Data {
+		s.Data[k] = []byte(v)
+	}
+	return c.retryOnConflict(ctx, func() error {
+		return c.tryUpsertSecret(ctx, copySecret(s))
+	})
+}
+
+// tryUpsertSecret reads the target and creates or updates it. ErrConflict or ErrAlreadyExists
+// is returned if the target changed between the read and the write.
+func (c *controller) tryUpsertSecret(ctx context.Context, s *Secret) error {
+	existing, err := c.client.getSecret(ctx, c.targetNamespace, c.targetName)
+	if err == ErrNotExist {
+		n, err := c.client.createSecret(ctx, s)
+		if err != nil {
+			return err
+		}
+		return c.postEvent("creating", n.objectReference())
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get secret %s/%s", c.targetNamespace, c.targetName)
+	}
+
+	//copy labels, annotations, version, and type
+	for k, v := range existing.Metadata.Annotations {
+		s.Metadata.Annotations[k] = v
+	}
+	for k, v := range existing.Metadata.Labels {
+		s.Metadata.Labels[k] = v
+	}
+	s.Metadata.ResourceVersion = existing.Metadata.ResourceVersion
+	s.Type = existing.Type
+
+	if compareSecrets(existing, s) {
+		return nil
+	}
+	n, err := c.client.updateSecret(ctx, s)
 	if err != nil {
 		return err
 	}
-	return c.postEvent("updating", n)
+	return c.postEvent("updating", n.objectReference())
 }
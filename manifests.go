@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// namespace of manifests that do not set one, as kubectl would use without a context
+const defaultNamespace = "default"
+
+// manifest is a configmap read from a local file rather than the API.
+type manifest struct {
+	ConfigMap *ConfigMap
+	File      string
+	// Line is the line the manifest's document starts on
+	Line int
+	// SpecLine is the line the spec's content starts on, or 0 if it is not a literal block
+	SpecLine int
+	// Err is why the document could not be read, in which case ConfigMap is nil
+	Err error
+}
+
+// manifestDocument is the subset of a kubernetes object that is read from a manifest.
+type manifestDocument struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Data       map[string]string `yaml:"data"`
+	Metadata   struct {
+		Name        string            `yaml:"name"`
+		Namespace   string            `yaml:"namespace"`
+		Labels      map[string]string `yaml:"labels"`
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+}
+
+// manifestFiles expands paths into the files they name. A directory is expanded to the
+// .yaml, .yml, and .json files directly in it, and anything else is treated as a glob.
+func manifestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			for _, ext := range []string{"*.yaml", "*.yml", "*.json"} {
+				matches, _ := filepath.Glob(filepath.Join(p, ext))
+				files = append(files, matches...)
+			}
+			continue
+		}
+
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %s", p)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("no files match %s", p)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readManifests reads every configmap in the files named by paths. Documents of other kinds are skipped.
+// Documents that can not be decoded are returned with Err set.
+func readManifests(paths []string) ([]*manifest, error) {
+	files, err := manifestFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []*manifest
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", file)
+		}
+		for _, doc := range splitDocuments(data) {
+			cm, err := decodeManifest(doc.Data)
+			if err != nil {
+				line, err := documentError(err, doc.Line)
+				manifests = append(manifests, &manifest{File: file, Line: line, Err: err})
+				continue
+			}
+			if cm == nil {
+				continue
+			}
+			m := &manifest{ConfigMap: cm, File: file, Line: doc.Line}
+			if n := specStart(doc.Data); n > 0 {
+				m.SpecLine = doc.Line + n - 1
+			}
+			manifests = append(manifests, m)
+		}
+	}
+	return manifests, nil
+}
+
+// yamlDocument is a single document from a multi-document YAML file.
+type yamlDocument struct {
+	Data []byte
+	// Line is the line in the file the document starts on
+	Line int
+}
+
+// splitDocuments splits data on "---" separator lines.
+func splitDocuments(data []byte) []*yamlDocument {
+	var docs []*yamlDocument
+	cur := &yamlDocument{Line: 1}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "---") && strings.TrimSpace(strings.TrimPrefix(line, "---")) == "" {
+			docs = append(docs, cur)
+			cur = &yamlDocument{Line: n + 1}
+			continue
+		}
+		cur.Data = append(cur.Data, line...)
+		cur.Data = append(cur.Data, '\n')
+	}
+	return append(docs, cur)
+}
+
+var yamlLineRE = regexp.MustCompile(`line (\d+)`)
+
+// documentError rewrites the line numbers in a YAML error from a document that starts on line start
+// so they are relative to the file. It returns the first such line, or start if there are none.
+func documentError(err error, start int) (int, error) {
+	var lines []int
+	msg := yamlLineRE.ReplaceAllStringFunc(err.Error(), func(s string) string {
+		n, _ := strconv.Atoi(yamlLineRE.FindStringSubmatch(s)[1])
+		lines = append(lines, start+n-1)
+		return fmt.Sprintf("line %d", start+n-1)
+	})
+	if len(lines) == 0 {
+		return start, err
+	}
+	return lines[0], errors.New(msg)
+}
+
+// specBlockRE matches the key of a spec written as a literal block, such as "  spec: |-".
+var specBlockRE = regexp.MustCompile(`^\s+` + specAnnotationKey + `:\s*\|[-+0-9]*\s*$`)
+
+// specStart returns the line in data that the content of the spec under data: starts on, or 0 if
+// the spec is not a literal block, whose lines are the lines of the spec as written.
+func specStart(data []byte) int {
+	inData := false
+	for i, line := range strings.Split(string(data), "\n") {
+		switch {
+		case line == "" || strings.HasPrefix(strings.TrimSpace(line), "#"):
+		case !strings.HasPrefix(line, " "):
+			inData = strings.TrimSpace(line) == "data:"
+		case inData && specBlockRE.MatchString(line):
+			return i + 2
+		}
+	}
+	return 0
+}
+
+// decodeManifest returns the configmap in data, or nil if data is empty or holds another kind of object.
+func decodeManifest(data []byte) (*ConfigMap, error) {
+	var doc manifestDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != "ConfigMap" {
+		return nil, nil
+	}
+
+	cm := &ConfigMap{
+		ApiVersion: doc.APIVersion,
+		Kind:       doc.Kind,
+		Data:       doc.Data,
+		Metadata: Metadata{
+			Name:        doc.Metadata.Name,
+			Namespace:   doc.Metadata.Namespace,
+			Labels:      doc.Metadata.Labels,
+			Annotations: doc.Metadata.Annotations,
+		},
+	}
+	if cm.Metadata.Namespace == "" {
+		cm.Metadata.Namespace = defaultNamespace
+	}
+	if cm.Metadata.Name == "" {
+		return nil, errors.New("configmap has no name")
+	}
+	return cm, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	type doc struct {
+		Line int
+		Data string
+	}
+	tests := []struct {
+		name string
+		in   string
+		want []doc
+	}{
+		{
+			name: "single document",
+			in:   "a: 1\nb: 2\n",
+			want: []doc{{1, "a: 1\nb: 2\n"}},
+		},
+		{
+			name: "separators",
+			in:   "a: 1\n---\nb: 2\n--- \nc: 3",
+			want: []doc{{1, "a: 1\n"}, {3, "b: 2\n"}, {5, "c: 3\n"}},
+		},
+		{
+			name: "leading separator",
+			in:   "---\na: 1\n",
+			want: []doc{{1, ""}, {2, "a: 1\n"}},
+		},
+		{
+			name: "separator in a value is kept",
+			in:   "a: |\n  ---\n  b\n---x\n",
+			want: []doc{{1, "a: |\n  ---\n  b\n---x\n"}},
+		},
+		{
+			name: "empty",
+			in:   "",
+			want: []doc{{1, ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []doc
+			for _, d := range splitDocuments([]byte(tt.in)) {
+				got = append(got, doc{d.Line, string(d.Data)})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentError(t *testing.T) {
+	tests := []struct {
+		err   string
+		start int
+		line  int
+		want  string
+	}{
+		{"yaml: line 3: did not find expected key", 10, 12, "yaml: line 12: did not find expected key"},
+		{"yaml: line 1: mapping values are not allowed", 1, 1, "yaml: line 1: mapping values are not allowed"},
+		{"yaml: unmarshal errors:\n  line 2: cannot unmarshal\n  line 5: cannot unmarshal", 4, 5, "yaml: unmarshal errors:\n  line 5: cannot unmarshal\n  line 8: cannot unmarshal"},
+		{"configmap has no name", 7, 7, "configmap has no name"},
+	}
+
+	for _, tt := range tests {
+		line, err := documentError(errors.New(tt.err), tt.start)
+		if line != tt.line || err.Error() != tt.want {
+			t.Errorf("documentError(%q, %d) = %d, %q, want %d, %q", tt.err, tt.start, line, err, tt.line, tt.want)
+		}
+	}
+}
+
+func TestSpecStart(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{
+			name: "literal block",
+			in:   "kind: ConfigMap\nmetadata:\n  name: a\ndata:\n  spec: |-\n    name: a\n",
+			want: 6,
+		},
+		{
+			name: "after other data keys and comments",
+			in:   "data:\n  other: x\n  # the spec\n\n  spec: |\n    name: a\nkind: ConfigMap\n",
+			want: 6,
+		},
+		{
+			name: "spec outside data",
+			in:   "metadata:\n  spec: |\n    x\ndata:\n  other: x\n",
+		},
+		{
+			name: "quoted spec",
+			in:   "data:\n  spec: \"name: a\"\n",
+		},
+		{
+			name: "folded spec",
+			in:   "data:\n  spec: >\n    name: a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := specStart([]byte(tt.in)); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeManifest(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want *ConfigMap
+		err  bool
+	}{
+		{
+			name: "configmap",
+			in:   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n  namespace: ns\n  annotations:\n    alertmanager-type: route\ndata:\n  spec: x\n",
+			want: &ConfigMap{
+				ApiVersion: "v1",
+				Kind:       "ConfigMap",
+				Data:       map[string]string{"spec": "x"},
+				Metadata: Metadata{
+					Name:        "a",
+					Namespace:   "ns",
+					Annotations: map[string]string{"alertmanager-type": "route"},
+				},
+			},
+		},
+		{
+			name: "default namespace",
+			in:   "kind: ConfigMap\nmetadata:\n  name: a\n",
+			want: &ConfigMap{Kind: "ConfigMap", Metadata: Metadata{Name: "a", Namespace: defaultNamespace}},
+		},
+		{
+			name: "json",
+			in:   `{"kind": "ConfigMap", "metadata": {"name": "a"}, "data": {"spec": "x"}}`,
+			want: &ConfigMap{Kind: "ConfigMap", Data: map[string]string{"spec": "x"}, Metadata: Metadata{Name: "a", Namespace: defaultNamespace}},
+		},
+		{
+			name: "other kind",
+			in:   "kind: Deployment\nmetadata:\n  name: a\n",
+		},
+		{
+			name: "empty",
+			in:   "",
+		},
+		{
+			name: "no name",
+			in:   "kind: ConfigMap\nmetadata:\n  namespace: ns\n",
+			err:  true,
+		},
+		{
+			name: "invalid",
+			in:   "kind: ConfigMap\n  bad: [\n",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeManifest([]byte(tt.in))
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// matcher types, as written in Alertmanager's matcher syntax
+const (
+	matchEqual     = "="
+	matchNotEqual  = "!="
+	matchRegexp    = "=~"
+	matchNotRegexp = "!~"
+)
+
+var matcherRE = regexp.MustCompile(`^\s*(` + labelNamePattern + `)\s*(=~|=|!=|!~)\s*((?s).*?)\s*$`)
+
+// matcher is a single parsed label matcher, such as severity="critical".
+type matcher struct {
+	Name  string
+	Type  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+// parseMatchers parses an entry from a matchers list. Like Alertmanager, an entry
+// may hold several comma separated matchers, optionally wrapped in braces.
+func parseMatchers(s string) ([]*matcher, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+		s = s[1 : len(s)-1]
+	}
+
+	var matchers []*matcher
+	for _, part := range splitMatchers(s) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		m, err := parseMatcher(part)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	if len(matchers) == 0 {
+		return nil, errors.Errorf("no matchers in %q", s)
+	}
+	return matchers, nil
+}
+
+// splitMatchers splits s on commas that are not inside a quoted value.
+func splitMatchers(s string) []string {
+	var parts []string
+	var quoted, escaped bool
+	start := 0
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseMatcher parses a single matcher such as foo="bar", foo!=bar, or foo=~"ba.*".
+func parseMatcher(s string) (*matcher, error) {
+	parts := matcherRE.FindStringSubmatch(s)
+	if parts == nil {
+		return nil, errors.Errorf("invalid matcher %q", s)
+	}
+
+	m := &matcher{
+		Name:  parts[1],
+		Type:  parts[2],
+		Value: parts[3],
+	}
+	if strings.HasPrefix(m.Value, `"`) {
+		v, err := strconv.Unquote(m.Value)
+		if err != nil {
+			return nil, errors.Errorf("invalid quoted value in matcher %q", s)
+		}
+		m.Value = v
+	}
+
+	if m.Type == matchRegexp || m.Type == matchNotRegexp {
+		re, err := compileMatchRE(m.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid regular expression in matcher %q", s)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// matches returns true if the label value v satisfies m. A missing label has the value "".
+func (m *matcher) matches(v string) bool {
+	switch m.Type {
+	case matchNotEqual:
+		return v != m.Value
+	case matchRegexp:
+		return m.re.MatchString(v)
+	case matchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return v == m.Value
+}
+
+// labelsMatch returns true if labels satisfy every matcher in the legacy match and match_re maps
+// and in the matchers list. A missing label has the value "". Invalid matchers never match.
+func labelsMatch(labels map[string]string, match, matchRE map[string]string, list []string) bool {
+	for k, v := range match {
+		if labels[k] != v {
+			return false
+		}
+	}
+	for k, v := range matchRE {
+		re, err := compileMatchRE(v)
+		if err != nil || !re.MatchString(labels[k]) {
+			return false
+		}
+	}
+	for _, s := range list {
+		matchers, err := parseMatchers(s)
+		if err != nil {
+			return false
+		}
+		for _, m := range matchers {
+			if !m.matches(labels[m.Name]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateMatchers returns an error if any entry in list can not be parsed.
+func validateMatchers(field string, list []string) error {
+	for _, s := range list {
+		if _, err := parseMatchers(s); err != nil {
+			return errors.Wrapf(err, "invalid %s", field)
+		}
+	}
+	return nil
+}
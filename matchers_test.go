@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitMatchers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{``, []string{``}},
+		{`a=b`, []string{`a=b`}},
+		{`a=b,c!=d`, []string{`a=b`, `c!=d`}},
+		{`a="b,c",d=e`, []string{`a="b,c"`, `d=e`}},
+		{`a="b\",c",d=e`, []string{`a="b\",c"`, `d=e`}},
+		{`a="b\\",c=d`, []string{`a="b\\"`, `c=d`}},
+		{`a=b,`, []string{`a=b`, ``}},
+	}
+
+	for _, tt := range tests {
+		if got := splitMatchers(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitMatchers(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMatchers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []matcher
+		err  bool
+	}{
+		{in: `severity="critical"`, want: []matcher{{Name: "severity", Type: matchEqual, Value: "critical"}}},
+		{in: `severity=critical`, want: []matcher{{Name: "severity", Type: matchEqual, Value: "critical"}}},
+		{in: ` team != "ops" `, want: []matcher{{Name: "team", Type: matchNotEqual, Value: "ops"}}},
+		{in: `team=~"infra|ops"`, want: []matcher{{Name: "team", Type: matchRegexp, Value: "infra|ops"}}},
+		{in: `team!~infra.*`, want: []matcher{{Name: "team", Type: matchNotRegexp, Value: "infra.*"}}},
+		{in: `empty=""`, want: []matcher{{Name: "empty", Type: matchEqual, Value: ""}}},
+		{in: `empty=`, want: []matcher{{Name: "empty", Type: matchEqual, Value: ""}}},
+		{in: `msg="a \"quoted\", value"`, want: []matcher{{Name: "msg", Type: matchEqual, Value: `a "quoted", value`}}},
+		{
+			in: `{severity="critical", team=~"infra|ops"}`,
+			want: []matcher{
+				{Name: "severity", Type: matchEqual, Value: "critical"},
+				{Name: "team", Type: matchRegexp, Value: "infra|ops"},
+			},
+		},
+		{in: `a=b,`, want: []matcher{{Name: "a", Type: matchEqual, Value: "b"}}},
+		{in: `_a1=b`, want: []matcher{{Name: "_a1", Type: matchEqual, Value: "b"}}},
+		{in: ``, err: true},
+		{in: `{}`, err: true},
+		{in: `severity`, err: true},
+		{in: `1abc=b`, err: true},
+		{in: `a-b=c`, err: true},
+		{in: `a="unterminated`, err: true},
+		{in: `a=~"("`, err: true},
+	}
+
+	for _, tt := range tests {
+		matchers, err := parseMatchers(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseMatchers(%q) did not return an error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMatchers(%q) returned %v", tt.in, err)
+			continue
+		}
+		var got []matcher
+		for _, m := range matchers {
+			got = append(got, matcher{Name: m.Name, Type: m.Type, Value: m.Value})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMatchers(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherMatches(t *testing.T) {
+	tests := []struct {
+		matcher string
+		value   string
+		want    bool
+	}{
+		{`a="b"`, "b", true},
+		{`a="b"`, "c", false},
+		{`a=""`, "", true},
+		{`a!="b"`, "c", true},
+		{`a!="b"`, "", true},
+		{`a=~"b|c"`, "c", true},
+		{`a=~"b"`, "bb", false},
+		{`a=~".*"`, "", true},
+		{`a!~"b|c"`, "d", true},
+		{`a!~"b|c"`, "b", false},
+	}
+
+	for _, tt := range tests {
+		matchers, err := parseMatchers(tt.matcher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchers[0].matches(tt.value); got != tt.want {
+			t.Errorf("%s matches %q = %v, want %v", tt.matcher, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// sharedReceiverKey is the annotation on a Receiver that allows routes in other
+// namespaces to use it when receiver names are qualified.
+const sharedReceiverKey = "alertmanager-shared-receiver"
+
+// receiverName returns the name to use for receiver name from namespace.
+// It is unchanged unless opts.ReceiverNameFormat is set.
+func (o *generateOptions) receiverName(namespace, name string) string {
+	if o.ReceiverNameFormat == "" {
+		return name
+	}
+	return strings.NewReplacer("{namespace}", namespace, "{name}", name).Replace(o.ReceiverNameFormat)
+}
+
+// receiverIndex tracks the receivers defined in each namespace so routes can be rewritten to use
+// qualified receiver names.
+type receiverIndex struct {
+	// names of the receivers in each namespace, as written
+	local map[string]map[string]bool
+	// configmap that defines each qualified name
+	qualified map[string]*ConfigMap
+	// namespace/name each qualified name was made from
+	origin map[string]string
+	// receivers in each namespace whose qualified name was already taken
+	collided map[string]map[string]string
+}
+
+func newReceiverIndex() *receiverIndex {
+	return &receiverIndex{
+		local:     make(map[string]map[string]bool),
+		qualified: make(map[string]*ConfigMap),
+		origin:    make(map[string]string),
+		collided:  make(map[string]map[string]string),
+	}
+}
+
+// qualify renames the receiver in f and records it in the index. A format such as
+// {namespace}-{name} can give receivers from different namespaces the same qualified name,
+// which would let one namespace receive the alerts of another. The receiver that was
+// qualified first keeps the name, and the other is left unchanged and an error is returned.
+func (x *receiverIndex) qualify(f *fragment, opts *generateOptions) error {
+	namespace := f.ConfigMap.Metadata.Namespace
+	qualified := opts.receiverName(namespace, f.Receiver.Name)
+	origin := namespace + "/" + f.Receiver.Name
+	if o, ok := x.origin[qualified]; ok && o != origin {
+		if x.collided[namespace] == nil {
+			x.collided[namespace] = make(map[string]string)
+		}
+		x.collided[namespace][f.Receiver.Name] = qualified
+		return errors.Errorf("qualified name %s of receiver %s is already the qualified name of receiver %s", qualified, origin, o)
+	}
+
+	if x.local[namespace] == nil {
+		x.local[namespace] = make(map[string]bool)
+	}
+	x.local[namespace][f.Receiver.Name] = true
+
+	f.Receiver.Name = qualified
+	x.qualified[qualified] = f.ConfigMap
+	x.origin[qualified] = origin
+	return nil
+}
+
+// qualifyRoute rewrites the receivers used by a route fragment to their qualified names. Receivers
+// from the route's namespace may be used by the name they were written with. Receivers from other
+// namespaces must be used by their qualified name and be marked as shared.
+func (x *receiverIndex) qualifyRoute(f *fragment, opts *generateOptions) error {
+	return x.qualifyRouteReceivers(f.Route, f.ConfigMap.Metadata.Namespace, opts)
+}
+
+func (x *receiverIndex) qualifyRouteReceivers(r *Route, namespace string, opts *generateOptions) error {
+	switch {
+	case r.Receiver == "":
+	case x.collided[namespace][r.Receiver] != "":
+		return errors.Errorf("receiver %s was rejected because its qualified name %s is already used by another namespace", r.Receiver, x.collided[namespace][r.Receiver])
+	case x.local[namespace][r.Receiver]:
+		r.Receiver = opts.receiverName(namespace, r.Receiver)
+	default:
+		// an undefined receiver is left for validation to report
+		cm, ok := x.qualified[r.Receiver]
+		if ok && cm.Metadata.Namespace != namespace && cm.Metadata.Annotations[sharedReceiverKey] != "true" {
+			return errors.Errorf("receiver %s from %s/%s is not shared with namespace %s", r.Receiver, cm.Metadata.Namespace, cm.Metadata.Name, namespace)
+		}
+	}
+
+	for _, child := range r.Routes {
+		if err := x.qualifyRouteReceivers(child, namespace, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var renderCmd = &cobra.Command{
+	Use:   "render path...",
+	Short: "Generates the config from configmap manifests in local files, directories, or globs",
+	Long: `Generates the config from configmap manifests in local files, directories, or globs, without
+connecting to kubernetes. The config is written to stdout, or to --output-file if it is set.`,
+	Run: runRender,
+}
+
+func runRender(cmd *cobra.Command, args []string) {
+	cfg, err := generateLocal(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "failed to marshal config"))
+	}
+	if _, err := loadConfig(string(data)); err != nil {
+		log.Fatal(errors.Wrap(err, "generated config is invalid"))
+	}
+
+	if outputPath == "" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := writeFileAtomic(outputPath, data); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generateLocal generates the config from the configmap manifests named by args, which
+// must name at least one. Warnings and rejected configmaps are logged.
+func generateLocal(args []string) (*Config, error) {
+	if len(args) == 0 {
+		return nil, errors.New("at least one file, directory, or glob is required")
+	}
+	options, err := generateOptionsFromFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := localConfigMaps(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, results, err := generateConfig(items, options)
+	logWarnings(results)
+	if err != nil {
+		return nil, err
+	}
+	if err := rejectionReport(results); err != nil {
+		log.Printf("generated config without some config maps: %v", err)
+	}
+	return cfg, nil
+}
+
+// localConfigMaps reads the configmaps in the manifests named by paths that match
+// the --selector and --namespace flags and may be part of the config.
+func localConfigMaps(paths []string) ([]*ConfigMap, error) {
+	manifests, err := selectManifests(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*ConfigMap
+	for _, m := range manifests {
+		if m.Err != nil {
+			return nil, errors.Wrapf(m.Err, "%s:%d", m.File, m.Line)
+		}
+		items = append(items, m.ConfigMap)
+	}
+	return items, nil
+}
+
+// selectManifests reads the manifests named by paths and returns those with configmaps that match
+// the --selector and --namespace flags and may be part of the config, and those that could not be read.
+func selectManifests(paths []string) ([]*manifest, error) {
+	sel, err := parseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := readManifests(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*manifest
+	for _, m := range manifests {
+		cm := m.ConfigMap
+		switch {
+		case m.Err != nil:
+		case len(namespaces) > 0 && !contains(namespaces, cm.Metadata.Namespace):
+			continue
+		case !sel.matches(cm.Metadata.Labels) || cm.Metadata.Annotations[typeAnnotationKey] == "":
+			continue
+		}
+		selected = append(selected, m)
+	}
+	return selected, nil
+}
+
+// logWarnings logs the warnings for every configmap in results.
+func logWarnings(results []*fragmentResult) {
+	for _, r := range results {
+		for _, w := range r.Warnings {
+			log.Printf("warning for %s/%s: %s", r.ConfigMap.Metadata.Namespace, r.ConfigMap.Metadata.Name, w.Message)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	routeDiffAlerts string
+	routeDiffTarget string
+	routeDiffFormat string
+)
+
+var routeDiffCmd = &cobra.Command{
+	Use:   "diff path...",
+	Short: "Shows which alerts would be sent to different receivers by a new route tree",
+	Long: `Replays the alerts in the file set by --alerts through the route tree of the current target and
+the route tree generated from configmap manifests in local files, directories, or globs, and shows
+how many alerts each receiver would get from each and which alerts would be sent somewhere else.
+The target is read from kubernetes as set by --target and --output-kind, or from --output-file when
+--output-kind=file.`,
+	Run: runRouteDiff,
+}
+
+// receiverCount is the number of alerts a receiver gets from each route tree.
+type receiverCount struct {
+	Receiver  string `json:"receiver"`
+	Current   int    `json:"current"`
+	Candidate int    `json:"candidate"`
+}
+
+// destinationChange is a label set that would be sent to different receivers.
+type destinationChange struct {
+	Labels map[string]string `json:"labels"`
+	// Count is the number of alerts with these labels
+	Count     int      `json:"count"`
+	Current   []string `json:"current"`
+	Candidate []string `json:"candidate"`
+}
+
+// routeDiff is the result of replaying alerts through two route trees.
+type routeDiff struct {
+	Alerts    int                  `json:"alerts"`
+	Changed   int                  `json:"changed"`
+	Receivers []*receiverCount     `json:"receivers"`
+	Changes   []*destinationChange `json:"changes"`
+}
+
+func runRouteDiff(cmd *cobra.Command, args []string) {
+	if routeDiffAlerts == "" {
+		log.Fatal("--alerts is required")
+	}
+	if routeDiffFormat != formatText && routeDiffFormat != formatJSON {
+		log.Fatalf("unknown value for --format %s", routeDiffFormat)
+	}
+
+	alerts, err := readAlerts(routeDiffAlerts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	candidate, err := generateLocal(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	current, err := currentConfig(routeDiffTarget)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	diff := diffRoutes(current.Route, candidate.Route, alerts)
+	if routeDiffFormat == formatJSON {
+		err = writeJSON(os.Stdout, diff)
+	} else {
+		err = writeRouteDiff(os.Stdout, diff)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// currentConfig reads the config the controller last wrote to the target. target is given
+// the way the controller's target is, as namespace/name or just a name in the client's namespace.
+func currentConfig(target string) (*Config, error) {
+	var data string
+	switch outputKind {
+	case outputFile:
+		if outputPath == "" {
+			return nil, errors.New("--output-file is required when the target is a file")
+		}
+		b, err := ioutil.ReadFile(outputPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", outputPath)
+		}
+		data = string(b)
+	case outputConfigMap, outputSecret:
+		client, err := newClient()
+		if err != nil {
+			return nil, err
+		}
+		if target == "" {
+			return nil, errors.Errorf("--target is required to read the target %s", outputKind)
+		}
+		namespace, name, err := parseTarget([]string{target}, client.namespace)
+		if err != nil {
+			return nil, err
+		}
+		if namespace == "" || name == "" {
+			return nil, errors.Errorf("namespace and name of target %s is required", outputKind)
+		}
+
+		if outputKind == outputSecret {
+			s, err := client.getSecret(context.Background(), namespace, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get secret %s/%s", namespace, name)
+			}
+			data = string(s.Data[configFileKey])
+		} else {
+			cm, err := client.getConfigMap(context.Background(), namespace, name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get configmap %s/%s", namespace, name)
+			}
+			data = cm.Data[configFileKey]
+		}
+	default:
+		return nil, errors.Errorf("unknown output kind %s", outputKind)
+	}
+
+	cfg, err := loadConfig(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "current config is invalid")
+	}
+	if cfg.Route == nil {
+		return nil, errors.New("current config has no route")
+	}
+	return cfg, nil
+}
+
+// diffRoutes sends every alert through the current and candidate route trees. Alerts with the
+// same labels are counted together. An alert sent to more than one receiver counts for each.
+func diffRoutes(current, candidate *Route, alerts []*alert) *routeDiff {
+	type labelSet struct {
+		labels map[string]string
+		count  int
+	}
+	var sets []*labelSet
+	seen := make(map[string]*labelSet)
+	for _, a := range alerts {
+		key := formatLabels(a.Labels)
+		if s, ok := seen[key]; ok {
+			s.count++
+			continue
+		}
+		s := &labelSet{labels: a.Labels, count: 1}
+		seen[key] = s
+		sets = append(sets, s)
+	}
+
+	diff := &routeDiff{
+		Alerts:    len(alerts),
+		Receivers: []*receiverCount{},
+		Changes:   []*destinationChange{},
+	}
+	counts := make(map[string]*receiverCount)
+	count := func(receiver string) *receiverCount {
+		c, ok := counts[receiver]
+		if !ok {
+			c = &receiverCount{Receiver: receiver}
+			counts[receiver] = c
+			diff.Receivers = append(diff.Receivers, c)
+		}
+		return c
+	}
+
+	for _, s := range sets {
+		before := matchedReceivers(testRoute(current, s.labels))
+		after := matchedReceivers(testRoute(candidate, s.labels))
+		for _, r := range before {
+			count(r).Current += s.count
+		}
+		for _, r := range after {
+			count(r).Candidate += s.count
+		}
+		if strings.Join(before, ",") != strings.Join(after, ",") {
+			diff.Changed += s.count
+			diff.Changes = append(diff.Changes, &destinationChange{
+				Labels:    s.labels,
+				Count:     s.count,
+				Current:   before,
+				Candidate: after,
+			})
+		}
+	}
+
+	sort.Slice(diff.Receivers, func(i, j int) bool {
+		return diff.Receivers[i].Receiver < diff.Receivers[j].Receiver
+	})
+	return diff
+}
+
+// matchedReceivers returns the sorted receivers of matches.
+func matchedReceivers(matches []*routeMatch) []string {
+	receivers := []string{}
+	for _, m := range matches {
+		receivers = append(receivers, m.Receiver)
+	}
+	sort.Strings(receivers)
+	return receivers
+}
+
+// writeRouteDiff writes diff as text.
+func writeRouteDiff(w io.Writer, diff *routeDiff) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d alerts would be sent to different receivers\n\n", diff.Changed, diff.Alerts)
+
+	tw := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECEIVER\tCURRENT\tCANDIDATE\tCHANGE")
+	for _, c := range diff.Receivers {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%+d\n", c.Receiver, c.Current, c.Candidate, c.Candidate-c.Current)
+	}
+	tw.Flush()
+
+	for _, c := range diff.Changes {
+		fmt.Fprintf(&b, "\n%s (%d alert(s))\n", formatLabels(c.Labels), c.Count)
+		fmt.Fprintf(&b, "  current:   %s\n", strings.Join(c.Current, ", "))
+		fmt.Fprintf(&b, "  candidate: %s\n", strings.Join(c.Candidate, ", "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// routeOrderKey is the annotation that sets the position of a Route among its siblings.
+// Routes with lower values come first. Ties are broken by namespace and name.
+const routeOrderKey = "alertmanager-route-order"
+
+// routeParentKey is the annotation on a Route that attaches it under the route defined
+// by another configmap, given as name or namespace/name, rather than the default route.
+const routeParentKey = "alertmanager-parent-route"
+
+// buildRouteTree attaches each route fragment to its parent and returns the top level route.
+// Routes defined in a fragment are kept as written, and attached routes are added after them.
+// Fragments that can not be attached, because their parent does not exist, is part of a
+// cycle, or was itself rejected, are returned with the reason, along with those already in
+// conflicts. Default routes in conflicts are not used, but routes under them are attached to the
+// default route that is.
+func buildRouteTree(routes []*fragment, conflicts map[*fragment]error) (*Route, map[*fragment]error) {
+	rejected := make(map[*fragment]error)
+	for _, f := range routes {
+		if err, ok := conflicts[f]; ok {
+			rejected[f] = err
+		}
+	}
+
+	byName := make(map[string]*fragment)
+	for _, f := range routes {
+		byName[f.String()] = f
+	}
+
+	var root *fragment
+	parents := make(map[*fragment]*fragment)
+	for _, f := range routes {
+		parent := f.ConfigMap.Metadata.Annotations[routeParentKey]
+		if f.DefaultRoute {
+			if _, ok := rejected[f]; ok {
+				continue
+			}
+			if parent != "" {
+				rejected[f] = errors.Errorf("default route %s can not have a parent route", f)
+				continue
+			}
+			root = f
+			continue
+		}
+
+		if parent == "" {
+			continue
+		}
+		if !strings.Contains(parent, "/") {
+			parent = f.ConfigMap.Metadata.Namespace + "/" + parent
+		}
+		p, ok := byName[parent]
+		if !ok {
+			rejected[f] = errors.Errorf("parent route %s of %s does not exist", parent, f)
+			continue
+		}
+		parents[f] = p
+	}
+
+	// reject every route that is part of a cycle
+	for _, f := range routes {
+		if _, ok := rejected[f]; ok {
+			continue
+		}
+		path := []string{f.String()}
+		for p := parents[f]; p != nil && len(path) <= len(routes); p = parents[p] {
+			path = append(path, p.String())
+			if p == f {
+				rejected[f] = errors.Errorf("route %s is part of a parent cycle: %s", f, strings.Join(path, " -> "))
+				break
+			}
+		}
+	}
+
+	// reject routes whose parent, or any ancestor, was rejected
+	for changed := true; changed; {
+		changed = false
+		for _, f := range routes {
+			if _, ok := rejected[f]; ok {
+				continue
+			}
+			p := parents[f]
+			if p == nil || p.DefaultRoute {
+				continue
+			}
+			if _, ok := rejected[p]; ok {
+				rejected[f] = errors.Errorf("parent route %s of %s was rejected", p, f)
+				changed = true
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, rejected
+	}
+
+	// routes are already sorted by namespace and name
+	sorted := append([]*fragment(nil), routes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Order < sorted[j].Order
+	})
+
+	for _, f := range sorted {
+		if _, ok := rejected[f]; ok || f.DefaultRoute {
+			continue
+		}
+		p := parents[f]
+		if p == nil || p.DefaultRoute {
+			p = root
+		}
+		p.Route.Routes = append(p.Route.Routes, f.Route)
+	}
+	return root.Route, rejected
+}
+
+// scopeRoute restricts a tenant's route to alerts from its own namespace by adding a matcher on
+// opts.NamespaceLabel. Routes that try to match a different namespace are rejected. Nothing is
+// done unless opts.NamespaceLabel is set or if the route is from one of opts.UnscopedNamespaces.
+func scopeRoute(f *fragment, opts *generateOptions) error {
+	if opts.NamespaceLabel == "" {
+		return nil
+	}
+
+	namespace := f.ConfigMap.Metadata.Namespace
+	for _, n := range opts.UnscopedNamespaces {
+		if n == namespace {
+			return nil
+		}
+	}
+
+	if f.DefaultRoute {
+		return errors.Errorf("default route %s must be defined in an unscoped namespace", f)
+	}
+
+	if err := checkNamespaceMatchers(f.Route, opts.NamespaceLabel, namespace); err != nil {
+		return errors.Wrapf(err, "route %s", f)
+	}
+
+	if f.Route.Match == nil {
+		f.Route.Match = make(map[string]string)
+	}
+	f.Route.Match[opts.NamespaceLabel] = namespace
+	return nil
+}
+
+// checkNamespaceMatchers returns an error if r or its children match label against anything but namespace.
+func checkNamespaceMatchers(r *Route, label, namespace string) error {
+	if v, ok := r.Match[label]; ok && v != namespace {
+		return errors.Errorf("may not match %s=%q, routes are limited to namespace %s", label, v, namespace)
+	}
+	if v, ok := r.MatchRE[label]; ok {
+		return errors.Errorf("may not match %s=~%q, routes are limited to namespace %s", label, v, namespace)
+	}
+	for _, s := range r.Matchers {
+		matchers, err := parseMatchers(s)
+		if err != nil {
+			return err
+		}
+		for _, m := range matchers {
+			if m.Name == label && (m.Type != matchEqual || m.Value != namespace) {
+				return errors.Errorf("may not match %s%s%q, routes are limited to namespace %s", label, m.Type, m.Value, namespace)
+			}
+		}
+	}
+	for _, child := range r.Routes {
+		if err := checkNamespaceMatchers(child, label, namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
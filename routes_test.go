@@ -0,0 +1,215 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// testRouteFragment is a route fragment whose receiver is its name, so the shape of a
+// tree can be written as receiver names. name may be namespace/name.
+type testRouteFragment struct {
+	name   string
+	parent string
+	order  int
+	root   bool
+}
+
+func (r testRouteFragment) fragment() *fragment {
+	ns, name := "default", r.name
+	if i := strings.Index(name, "/"); i >= 0 {
+		ns, name = name[:i], name[i+1:]
+	}
+	cm := newConfigMap(ns, name)
+	cm.Metadata.Annotations[typeAnnotationKey] = kindRoute
+	if r.parent != "" {
+		cm.Metadata.Annotations[routeParentKey] = r.parent
+	}
+	return &fragment{
+		ConfigMap:    cm,
+		Kind:         kindRoute,
+		Route:        &Route{Receiver: name},
+		DefaultRoute: r.root,
+		Order:        r.order,
+	}
+}
+
+// describeRoute writes r as its receiver followed by its children in brackets.
+func describeRoute(r *Route) string {
+	if r == nil {
+		return ""
+	}
+	s := r.Receiver
+	if len(r.Routes) > 0 {
+		var children []string
+		for _, c := range r.Routes {
+			children = append(children, describeRoute(c))
+		}
+		s += "[" + strings.Join(children, ",") + "]"
+	}
+	return s
+}
+
+func TestBuildRouteTree(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []testRouteFragment
+		// names of routes that lost a conflict
+		conflicts []string
+		tree      string
+		rejected  map[string]string
+	}{
+		{
+			name: "siblings in name order",
+			routes: []testRouteFragment{
+				{name: "root", root: true},
+				{name: "a"},
+				{name: "b"},
+				{name: "c"},
+			},
+			tree: "root[a,b,c]",
+		},
+		{
+			name: "order before name",
+			routes: []testRouteFragment{
+				{name: "root", root: true},
+				{name: "a", order: 10},
+				{name: "b"},
+				{name: "c", order: -1},
+			},
+			tree: "root[c,b,a]",
+		},
+		{
+			name: "nested parents",
+			routes: []testRouteFragment{
+				{name: "a"},
+				{name: "b", parent: "a"},
+				{name: "c", parent: "b"},
+				{name: "d", parent: "a"},
+				{name: "root", root: true},
+			},
+			tree: "root[a[b[c],d]]",
+		},
+		{
+			name: "parent in another namespace",
+			routes: []testRouteFragment{
+				{name: "monitoring/pager"},
+				{name: "root", root: true},
+				{name: "team/child", parent: "monitoring/pager"},
+			},
+			tree: "root[pager[child]]",
+		},
+		{
+			name: "dangling parent",
+			routes: []testRouteFragment{
+				{name: "root", root: true},
+				{name: "a", parent: "missing"},
+				{name: "b", parent: "a"},
+			},
+			tree: "root",
+			rejected: map[string]string{
+				"a": "parent route default/missing of default/a does not exist",
+				"b": "parent route default/a of default/b was rejected",
+			},
+		},
+		{
+			name: "cycle",
+			routes: []testRouteFragment{
+				{name: "root", root: true},
+				{name: "a", parent: "b"},
+				{name: "b", parent: "a"},
+				{name: "c", parent: "a"},
+				{name: "d"},
+			},
+			tree: "root[d]",
+			rejected: map[string]string{
+				"a": "route default/a is part of a parent cycle: default/a -> default/b -> default/a",
+				"b": "route default/b is part of a parent cycle: default/b -> default/a -> default/b",
+				"c": "parent route default/a of default/c was rejected",
+			},
+		},
+		{
+			name: "own parent",
+			routes: []testRouteFragment{
+				{name: "root", root: true},
+				{name: "a", parent: "a"},
+			},
+			tree: "root",
+			rejected: map[string]string{
+				"a": "route default/a is part of a parent cycle: default/a -> default/a",
+			},
+		},
+		{
+			name: "default route with a parent",
+			routes: []testRouteFragment{
+				{name: "a"},
+				{name: "root", root: true, parent: "a"},
+			},
+			rejected: map[string]string{
+				"root": "default route default/root can not have a parent route",
+			},
+		},
+		{
+			name: "routes under a losing default route",
+			routes: []testRouteFragment{
+				{name: "loser", root: true},
+				{name: "root", root: true},
+				{name: "a", parent: "loser"},
+				{name: "b", parent: "root"},
+			},
+			conflicts: []string{"loser"},
+			tree:      "root[a,b]",
+			rejected: map[string]string{
+				"loser": "conflict",
+			},
+		},
+		{
+			name: "no default route",
+			routes: []testRouteFragment{
+				{name: "a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var routes []*fragment
+			byName := make(map[string]*fragment)
+			for _, r := range tt.routes {
+				f := r.fragment()
+				routes = append(routes, f)
+				byName[f.ConfigMap.Metadata.Name] = f
+			}
+			// fragments are sorted by namespace and name before the tree is built
+			sort.SliceStable(routes, func(i, j int) bool {
+				return routes[i].String() < routes[j].String()
+			})
+			conflicts := make(map[*fragment]error)
+			for _, name := range tt.conflicts {
+				conflicts[byName[name]] = errors.New("conflict")
+			}
+
+			root, rejected := buildRouteTree(routes, conflicts)
+			if got := describeRoute(root); got != tt.tree {
+				t.Errorf("got tree %q, want %q", got, tt.tree)
+			}
+
+			got := make(map[string]string)
+			for f, err := range rejected {
+				got[f.ConfigMap.Metadata.Name] = err.Error()
+			}
+			for name, want := range tt.rejected {
+				if got[name] != want {
+					t.Errorf("got rejection %q for %s, want %q", got[name], name, want)
+				}
+			}
+			for name, err := range got {
+				if _, ok := tt.rejected[name]; !ok {
+					t.Errorf("%s was rejected: %s", name, err)
+				}
+			}
+		})
+	}
+}
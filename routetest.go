@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// values Alertmanager uses when the top level route does not set them
+const (
+	defaultGroupWait      = "30s"
+	defaultGroupInterval  = "5m"
+	defaultRepeatInterval = "4h"
+)
+
+var (
+	routeTestLabels []string
+	routeTestFormat string
+)
+
+var routeCmd = &cobra.Command{
+	Use:   "route",
+	Short: "Commands for inspecting the route tree",
+}
+
+var routeTestCmd = &cobra.Command{
+	Use:   "test path...",
+	Short: "Shows which receivers would get an alert with the given labels",
+	Long: `Shows which receivers would get an alert with the labels set by --label, using the route tree
+generated from configmap manifests in local files, directories, or globs.`,
+	Run: runRouteTest,
+}
+
+// routeStep is a route that matched on the way to a receiver.
+type routeStep struct {
+	Receiver  string   `json:"receiver,omitempty"`
+	Matchers  []string `json:"matchers,omitempty"`
+	Continue  bool     `json:"continue,omitempty"`
+	ConfigMap string   `json:"configMap,omitempty"`
+}
+
+// routeMatch is a route that an alert would be sent by, with its settings after inheritance.
+type routeMatch struct {
+	Path           []*routeStep `json:"path"`
+	Receiver       string       `json:"receiver"`
+	GroupBy        []string     `json:"group_by"`
+	GroupWait      string       `json:"group_wait"`
+	GroupInterval  string       `json:"group_interval"`
+	RepeatInterval string       `json:"repeat_interval"`
+}
+
+func runRouteTest(cmd *cobra.Command, args []string) {
+	if routeTestFormat != formatText && routeTestFormat != formatJSON {
+		log.Fatalf("unknown value for --format %s", routeTestFormat)
+	}
+	labels, err := parseLabels(routeTestLabels)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := generateLocal(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	matches := testRoute(cfg.Route, labels)
+	if routeTestFormat == formatJSON {
+		err = writeJSON(os.Stdout, matches)
+	} else {
+		err = writeRouteMatches(os.Stdout, labels, matches)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseLabels parses name=value pairs into a label set.
+func parseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, p := range pairs {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 || !labelNameRE.MatchString(parts[0]) {
+			return nil, errors.Errorf("invalid label %q, expected name=value", p)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// testRoute returns the routes under root that an alert with labels would be sent by,
+// following Alertmanager's rules: the first matching child is used unless it sets
+// continue, and a route with no matching children is used itself.
+func testRoute(root *Route, labels map[string]string) []*routeMatch {
+	top := &routeMatch{
+		Receiver:       root.Receiver,
+		GroupBy:        []string{},
+		GroupWait:      defaultGroupWait,
+		GroupInterval:  defaultGroupInterval,
+		RepeatInterval: defaultRepeatInterval,
+	}
+	return walkRoute(root, top, labels)
+}
+
+func walkRoute(r *Route, parent *routeMatch, labels map[string]string) []*routeMatch {
+	if !routeMatches(r, labels) {
+		return nil
+	}
+
+	m := &routeMatch{
+		Path:           append(append([]*routeStep(nil), parent.Path...), newRouteStep(r)),
+		Receiver:       parent.Receiver,
+		GroupBy:        parent.GroupBy,
+		GroupWait:      parent.GroupWait,
+		GroupInterval:  parent.GroupInterval,
+		RepeatInterval: parent.RepeatInterval,
+	}
+	if r.Receiver != "" {
+		m.Receiver = r.Receiver
+	}
+	if r.GroupBy != nil {
+		m.GroupBy = r.GroupBy
+	}
+	for _, d := range []struct {
+		value *string
+		dest  *string
+	}{
+		{r.GroupWait, &m.GroupWait},
+		{r.GroupInterval, &m.GroupInterval},
+		{r.RepeatInterval, &m.RepeatInterval},
+	} {
+		if d.value != nil {
+			*d.dest = *d.value
+		}
+	}
+
+	var all []*routeMatch
+	for _, child := range r.Routes {
+		matches := walkRoute(child, m, labels)
+		all = append(all, matches...)
+		if len(matches) > 0 && !child.Continue {
+			break
+		}
+	}
+	if len(all) == 0 {
+		all = append(all, m)
+	}
+	return all
+}
+
+// routeMatches returns true if labels satisfy every matcher on r.
+func routeMatches(r *Route, labels map[string]string) bool {
+	return labelsMatch(labels, r.Match, r.MatchRE, r.Matchers)
+}
+
+func newRouteStep(r *Route) *routeStep {
+	s := &routeStep{
+		Receiver: r.Receiver,
+		Continue: r.Continue,
+	}
+	for k, v := range r.Match {
+		s.Matchers = append(s.Matchers, fmt.Sprintf("%s=%q", k, v))
+	}
+	for k, v := range r.MatchRE {
+		s.Matchers = append(s.Matchers, fmt.Sprintf("%s=~%q", k, v))
+	}
+	sort.Strings(s.Matchers)
+	s.Matchers = append(s.Matchers, r.Matchers...)
+	if r.source != nil {
+		s.ConfigMap = configMapKey(r.source)
+	}
+	return s
+}
+
+// writeRouteMatches writes matches as text.
+func writeRouteMatches(w io.Writer, labels map[string]string, matches []*routeMatch) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "alert %s is sent to %d receiver(s)\n", formatLabels(labels), len(matches))
+	for i, m := range matches {
+		fmt.Fprintf(&b, "\n%d. %s\n", i+1, m.Receiver)
+		fmt.Fprintf(&b, "   group_by: [%s]\n", strings.Join(m.GroupBy, ", "))
+		fmt.Fprintf(&b, "   group_wait: %s, group_interval: %s, repeat_interval: %s\n", m.GroupWait, m.GroupInterval, m.RepeatInterval)
+		fmt.Fprintf(&b, "   path:\n")
+		for _, s := range m.Path {
+			desc := "{" + strings.Join(s.Matchers, ", ") + "}"
+			if s.Receiver != "" {
+				desc += " receiver=" + s.Receiver
+			}
+			if s.Continue {
+				desc += " continue"
+			}
+			source := s.ConfigMap
+			if source == "" {
+				source = "unknown"
+			}
+			fmt.Fprintf(&b, "   - %s (%s)\n", desc, source)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// routeTestHandler serves the routes an alert would be sent by using the last config written
+// by the controller. Each query parameter is a label. With leader election, only the leader
+// writes the config, so other replicas can not answer.
+func (c *controller) routeTestHandler(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	root := c.route
+	c.mu.Unlock()
+	if root == nil {
+		msg := "no config has been written yet"
+		if c.leaderElect {
+			msg += ". only the leader writes the config, so this replica may be on standby"
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+		return
+	}
+
+	labels := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if !labelNameRE.MatchString(k) {
+			http.Error(w, fmt.Sprintf("invalid label name %q", k), http.StatusBadRequest)
+			return
+		}
+		labels[k] = v[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := writeJSON(w, testRoute(root, labels)); err != nil {
+		log.Printf("failed to write route test response: %v", err)
+	}
+}
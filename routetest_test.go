@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// routeTestTree is the route tree the route test cases are sent through.
+const routeTestTree = `
+receiver: root
+group_by: [alertname]
+routes:
+- receiver: critical
+  match:
+    severity: critical
+  group_wait: 10s
+  routes:
+  - receiver: critical-db
+    matchers: ['service=~"db|cache"']
+    group_by: [alertname, instance]
+  - receiver: critical-web
+    match_re:
+      service: web.*
+    repeat_interval: 1h
+- receiver: audit
+  matchers: ['team="ops"']
+  continue: true
+- receiver: ops
+  matchers: ['team="ops"']
+  group_interval: 1m
+- receiver: ops-fallback
+  matchers: ['team="ops"']
+- routes:
+  - receiver: nested
+    match:
+      env: staging
+`
+
+func TestTestRoute(t *testing.T) {
+	var root Route
+	if err := yaml.Unmarshal([]byte(routeTestTree), &root); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		// each match as receiver, group_by, group_wait, group_interval, and repeat_interval
+		want []string
+	}{
+		{
+			name:   "no child matches",
+			labels: map[string]string{"severity": "warning"},
+			want:   []string{"root [alertname] 30s 5m 4h"},
+		},
+		{
+			name:   "matching route without matching children",
+			labels: map[string]string{"severity": "critical", "service": "api"},
+			want:   []string{"critical [alertname] 10s 5m 4h"},
+		},
+		{
+			name:   "inherits and overrides settings",
+			labels: map[string]string{"severity": "critical", "service": "cache"},
+			want:   []string{"critical-db [alertname instance] 10s 5m 4h"},
+		},
+		{
+			name:   "first matching sibling wins",
+			labels: map[string]string{"severity": "critical", "service": "web-frontend"},
+			want:   []string{"critical-web [alertname] 10s 5m 1h"},
+		},
+		{
+			name:   "continue goes on to the next match",
+			labels: map[string]string{"team": "ops"},
+			want:   []string{"audit [alertname] 30s 5m 4h", "ops [alertname] 30s 1m 4h"},
+		},
+		{
+			name:   "continue stops at the first match without it",
+			labels: map[string]string{"severity": "critical", "team": "ops"},
+			want:   []string{"critical [alertname] 10s 5m 4h"},
+		},
+		{
+			name:   "route without a receiver uses its parent's",
+			labels: map[string]string{"env": "prod"},
+			want:   []string{"root [alertname] 30s 5m 4h"},
+		},
+		{
+			name:   "nested under a route without matchers",
+			labels: map[string]string{"env": "staging"},
+			want:   []string{"nested [alertname] 30s 5m 4h"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, m := range testRoute(&root, tt.labels) {
+				got = append(got, fmt.Sprintf("%s [%s] %s %s %s", m.Receiver, strings.Join(m.GroupBy, " "), m.GroupWait, m.GroupInterval, m.RepeatInterval))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
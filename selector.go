@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// label selector operators
+const (
+	selectEqual        = "="
+	selectNotEqual     = "!="
+	selectIn           = "in"
+	selectNotIn        = "notin"
+	selectExists       = "exists"
+	selectDoesNotExist = "!"
+)
+
+var (
+	selectorSetRE    = regexp.MustCompile(`^([^\s!=]+)\s+(in|notin)\s+\((.*)\)$`)
+	selectorEqualRE  = regexp.MustCompile(`^([^\s!=]+)\s*(==|=|!=)\s*([^\s!=]*)$`)
+	selectorExistsRE = regexp.MustCompile(`^(!?)\s*([^\s!=]+)$`)
+)
+
+// labelSelector is a parsed kubernetes label selector, used when there is no API server to apply it.
+type labelSelector []*selectorRequirement
+
+type selectorRequirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// parseSelector parses a label selector such as "type=alertmanager,tier in (a, b),!legacy".
+func parseSelector(s string) (labelSelector, error) {
+	var sel labelSelector
+	for _, part := range splitSelector(s) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		r := &selectorRequirement{}
+		if m := selectorSetRE.FindStringSubmatch(part); m != nil {
+			r.Key, r.Operator = m[1], m[2]
+			for _, v := range strings.Split(m[3], ",") {
+				r.Values = append(r.Values, strings.TrimSpace(v))
+			}
+		} else if m := selectorEqualRE.FindStringSubmatch(part); m != nil {
+			r.Key, r.Operator, r.Values = m[1], m[2], []string{m[3]}
+			if r.Operator == "==" {
+				r.Operator = selectEqual
+			}
+		} else if m := selectorExistsRE.FindStringSubmatch(part); m != nil {
+			r.Key, r.Operator = m[2], selectExists
+			if m[1] == "!" {
+				r.Operator = selectDoesNotExist
+			}
+		} else {
+			return nil, errors.Errorf("invalid label selector %q", part)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// splitSelector splits s on commas that are not inside a set of values.
+func splitSelector(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// matches returns true if labels satisfies every requirement in the selector.
+func (sel labelSelector) matches(labels map[string]string) bool {
+	for _, r := range sel {
+		v, ok := labels[r.Key]
+		switch r.Operator {
+		case selectEqual, selectIn:
+			if !ok || !contains(r.Values, v) {
+				return false
+			}
+		case selectNotEqual, selectNotIn:
+			if ok && contains(r.Values, v) {
+				return false
+			}
+		case selectExists:
+			if !ok {
+				return false
+			}
+		case selectDoesNotExist:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in   string
+		want labelSelector
+		err  bool
+	}{
+		{in: ``},
+		{in: ` , `},
+		{
+			in:   `type=alertmanager`,
+			want: labelSelector{{Key: "type", Operator: selectEqual, Values: []string{"alertmanager"}}},
+		},
+		{
+			in:   `type == alertmanager`,
+			want: labelSelector{{Key: "type", Operator: selectEqual, Values: []string{"alertmanager"}}},
+		},
+		{
+			in:   `type!=legacy`,
+			want: labelSelector{{Key: "type", Operator: selectNotEqual, Values: []string{"legacy"}}},
+		},
+		{
+			in:   `type=`,
+			want: labelSelector{{Key: "type", Operator: selectEqual, Values: []string{""}}},
+		},
+		{
+			in:   `tier in (a, b)`,
+			want: labelSelector{{Key: "tier", Operator: selectIn, Values: []string{"a", "b"}}},
+		},
+		{
+			in:   `tier notin (a)`,
+			want: labelSelector{{Key: "tier", Operator: selectNotIn, Values: []string{"a"}}},
+		},
+		{
+			in:   `app.kubernetes.io/part-of`,
+			want: labelSelector{{Key: "app.kubernetes.io/part-of", Operator: selectExists}},
+		},
+		{
+			in:   `!legacy`,
+			want: labelSelector{{Key: "legacy", Operator: selectDoesNotExist}},
+		},
+		{
+			in: `type=alertmanager,tier in (a, b),!legacy`,
+			want: labelSelector{
+				{Key: "type", Operator: selectEqual, Values: []string{"alertmanager"}},
+				{Key: "tier", Operator: selectIn, Values: []string{"a", "b"}},
+				{Key: "legacy", Operator: selectDoesNotExist},
+			},
+		},
+		{in: `a=b=c`, err: true},
+		{in: `a b`, err: true},
+		{in: `tier in a`, err: true},
+		{in: `=b`, err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSelector(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseSelector(%q) did not return an error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSelector(%q) returned %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSelector(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"type": "alertmanager", "tier": "a", "empty": ""}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{``, true},
+		{`type=alertmanager`, true},
+		{`type=other`, false},
+		{`missing=`, false},
+		{`empty=`, true},
+		{`type!=other`, true},
+		{`missing!=x`, true},
+		{`tier in (a, b)`, true},
+		{`tier in (b)`, false},
+		{`tier notin (b)`, true},
+		{`missing notin (b)`, true},
+		{`tier`, true},
+		{`missing`, false},
+		{`!missing`, true},
+		{`!tier`, false},
+		{`type=alertmanager,!tier`, false},
+	}
+
+	for _, tt := range tests {
+		sel, err := parseSelector(tt.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.matches(labels); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// annotations written to source configmaps so owners can see if they were used
+const (
+	statusAnnotationKey       = "alertmanager-status"
+	statusReasonAnnotationKey = "alertmanager-status-reason"
+	statusHashAnnotationKey   = "alertmanager-status-hash"
+
+	statusAccepted   = "accepted"
+	statusRejected   = "rejected"
+	statusOverridden = "overridden"
+)
+
+// fragmentResult records whether a source configmap was used in the generated config.
+type fragmentResult struct {
+	ConfigMap *ConfigMap
+	// Err is why the configmap was rejected, or nil if it was accepted
+	Err error
+	// Warnings are problems that did not stop the configmap from being used
+	Warnings []warning
+}
+
+// warning is a problem with a configmap that does not stop it from being used.
+type warning struct {
+	// Reason is a short CamelCase reason, used for events
+	Reason  string
+	Message string
+}
+
+// status returns the annotations that describe r.
+func (r *fragmentResult) status() map[string]string {
+	a := map[string]string{
+		statusAnnotationKey:       statusAccepted,
+		statusReasonAnnotationKey: "",
+		statusHashAnnotationKey:   hashConfigMap(r.ConfigMap),
+	}
+	switch {
+	case isConflict(r.Err):
+		a[statusAnnotationKey] = statusOverridden
+		a[statusReasonAnnotationKey] = r.Err.Error()
+	case r.Err != nil:
+		a[statusAnnotationKey] = statusRejected
+		a[statusReasonAnnotationKey] = r.Err.Error()
+	case len(r.Warnings) > 0:
+		var msgs []string
+		for _, w := range r.Warnings {
+			msgs = append(msgs, w.Message)
+		}
+		a[statusReasonAnnotationKey] = strings.Join(msgs, "; ")
+	}
+	return a
+}
+
+// reportStatus annotates each source configmap with its status and posts a
+// Warning event when one is newly rejected or has warnings. Configmaps whose status has not
+// changed are left alone to avoid needless writes. When the config was not
+// written, only rejected configmaps are updated, since the others were not used either.
+func (c *controller) reportStatus(results []*fragmentResult, written bool) {
+	for _, r := range results {
+		if !written && r.Err == nil {
+			continue
+		}
+		cm := r.ConfigMap
+		status := r.status()
+
+		changed := false
+		for k, v := range status {
+			if cm.Metadata.Annotations[k] != v {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		if err := c.client.patchConfigMapAnnotations(cm.Metadata.Namespace, cm.Metadata.Name, status); err != nil {
+			log.Printf("failed to update status of %s/%s: %v", cm.Metadata.Namespace, cm.Metadata.Name, err)
+		}
+
+		switch {
+		case isConflict(r.Err):
+			log.Printf("overridden %s/%s: %v", cm.Metadata.Namespace, cm.Metadata.Name, r.Err)
+			c.warn("Conflict", r.Err.Error(), cm)
+		case r.Err != nil:
+			log.Printf("rejected %s/%s: %v", cm.Metadata.Namespace, cm.Metadata.Name, r.Err)
+			c.warn("Rejected", r.Err.Error(), cm)
+		}
+		for _, w := range r.Warnings {
+			log.Printf("warning for %s/%s: %s", cm.Metadata.Namespace, cm.Metadata.Name, w.Message)
+			c.warn(w.Reason, w.Message, cm)
+		}
+	}
+}
+
+// warn posts a Warning event on cm, logging any failure to do so.
+func (c *controller) warn(reason, message string, cm *ConfigMap) {
+	if err := c.postWarning(reason, message, cm.objectReference()); err != nil {
+		log.Printf("failed to post event for %s/%s: %v", cm.Metadata.Namespace, cm.Metadata.Name, err)
+	}
+}
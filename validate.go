@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// diagnostic severities
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// formats for validate output
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+var validateFormat string
+
+var validateCmd = &cobra.Command{
+	Use:   "validate path...",
+	Short: "Checks configmap manifests in local files, directories, or globs for problems",
+	Long: `Checks configmap manifests in local files, directories, or globs for every problem the controller
+would find in them, without connecting to kubernetes or writing anything. Exits with a non-zero
+status if there are any errors.`,
+	Run: runValidate,
+}
+
+// diagnostic is a problem found in a manifest. File and ConfigMap are empty for
+// problems with the config as a whole.
+type diagnostic struct {
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	ConfigMap string `json:"configMap,omitempty"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+}
+
+func (d *diagnostic) String() string {
+	s := ""
+	if d.File != "" {
+		s = fmt.Sprintf("%s:%d: ", d.File, d.Line)
+	}
+	s += d.Severity + ": "
+	if d.ConfigMap != "" {
+		s += d.ConfigMap + ": "
+	}
+	return s + d.Message
+}
+
+func runValidate(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		log.Fatal("at least one file, directory, or glob is required")
+	}
+	if validateFormat != formatText && validateFormat != formatJSON {
+		log.Fatalf("unknown value for --format %s", validateFormat)
+	}
+	options, err := generateOptionsFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	manifests, err := selectManifests(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	diagnostics := validateManifests(manifests, options)
+	if err := writeDiagnostics(os.Stdout, diagnostics, validateFormat); err != nil {
+		log.Fatal(err)
+	}
+	for _, d := range diagnostics {
+		if d.Severity == severityError {
+			os.Exit(1)
+		}
+	}
+}
+
+// validateManifests returns every problem found while generating the config from manifests.
+func validateManifests(manifests []*manifest, opts *generateOptions) []*diagnostic {
+	var diagnostics []*diagnostic
+	var items []*ConfigMap
+	sources := make(map[*ConfigMap]*manifest)
+	for _, m := range manifests {
+		if m.Err != nil {
+			diagnostics = append(diagnostics, &diagnostic{
+				File:     m.File,
+				Line:     m.Line,
+				Severity: severityError,
+				Message:  m.Err.Error(),
+			})
+			continue
+		}
+		items = append(items, m.ConfigMap)
+		sources[m.ConfigMap] = m
+	}
+
+	// keep going after the first rejected configmap so every problem is found
+	tolerant := *opts
+	tolerant.Tolerant = true
+
+	cfg, results, err := generateConfig(items, &tolerant)
+	for _, r := range results {
+		m := sources[r.ConfigMap]
+		add := func(severity string, line int, message string) {
+			diagnostics = append(diagnostics, &diagnostic{
+				File:      m.File,
+				Line:      line,
+				ConfigMap: configMapKey(r.ConfigMap),
+				Severity:  severity,
+				Message:   message,
+			})
+		}
+
+		switch {
+		case isConflict(r.Err):
+			add(severityWarning, m.Line, r.Err.Error())
+		case r.Err != nil:
+			// line numbers in a spec that can not be parsed are made relative to the file
+			line, err := m.Line, r.Err
+			if _, ok := errors.Cause(err).(specError); ok && m.SpecLine > 0 {
+				line, err = documentError(err, m.SpecLine)
+			}
+			// configmaps the controller ignores are still mistakes when they are selected
+			add(severityError, line, err.Error())
+		}
+		for _, w := range r.Warnings {
+			add(severityWarning, m.Line, w.Message)
+		}
+	}
+
+	if err == nil {
+		var data []byte
+		data, err = yaml.Marshal(cfg)
+		if err == nil {
+			_, err = loadConfig(string(data))
+		}
+	}
+	if err != nil {
+		diagnostics = append(diagnostics, &diagnostic{Severity: severityError, Message: err.Error()})
+	}
+
+	// problems with the config as a whole come last
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i], diagnostics[j]
+		switch {
+		case a.File == "" || b.File == "":
+			return b.File == "" && a.File != ""
+		case a.File != b.File:
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return diagnostics
+}
+
+// writeDiagnostics writes diagnostics to w as text, one per line, or as a JSON object.
+func writeDiagnostics(w io.Writer, diagnostics []*diagnostic, format string) error {
+	if format == formatJSON {
+		valid := true
+		for _, d := range diagnostics {
+			if d.Severity == severityError {
+				valid = false
+			}
+		}
+		if diagnostics == nil {
+			diagnostics = []*diagnostic{}
+		}
+		return writeJSON(w, struct {
+			Valid       bool          `json:"valid"`
+			Diagnostics []*diagnostic `json:"diagnostics"`
+		}{valid, diagnostics})
+	}
+
+	for _, d := range diagnostics {
+		if _, err := fmt.Fprintln(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
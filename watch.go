@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// how long to wait before restarting a failed list or watch
+const watchRetryInterval = 5 * time.Second
+
+// run processes configmaps whenever a relevant configmap changes, and every
+// syncInterval as a safety net, until ctx is canceled.
+func (c *controller) run(ctx context.Context) {
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+
+	for _, n := range c.namespaces {
+		go c.watchNamespace(ctx, n, notify)
+	}
+
+	for {
+		if err := c.process(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("failed to process config maps: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.syncInterval):
+		case <-trigger:
+			// collect any other changes that arrive shortly after
+			// the first so a burst of updates is processed once.
+			timer := time.NewTimer(c.debounce)
+		DEBOUNCE:
+			for {
+				select {
+				case <-trigger:
+				case <-timer.C:
+					break DEBOUNCE
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		}
+	}
+}
+
+// watchNamespace watches configmaps in a single namespace and calls notify
+// when one that may affect the generated config changes.
+func (c *controller) watchNamespace(ctx context.Context, namespace string, notify func()) {
+	var resourceVersion string
+	for {
+		if resourceVersion == "" {
+			rv, err := c.client.listConfigMaps(namespace, c.selector, func(*ConfigMap) {})
+			if err != nil {
+				log.Printf("failed to list config maps for %s %s: %v", namespace, c.selector, err)
+				if !sleepContext(ctx, watchRetryInterval) {
+					return
+				}
+				continue
+			}
+			resourceVersion = rv
+			// we may have missed events while not watching
+			notify()
+		}
+
+		rv, err := c.client.watchConfigMaps(ctx, namespace, c.selector, resourceVersion, func(eventType string, cm *ConfigMap) {
+			if c.isRelevant(eventType, cm) {
+				notify()
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == ErrGone:
+			resourceVersion = ""
+		case err != nil:
+			log.Printf("failed to watch config maps for %s %s: %v", namespace, c.selector, err)
+			resourceVersion = rv
+			if !sleepContext(ctx, watchRetryInterval) {
+				return
+			}
+		default:
+			resourceVersion = rv
+		}
+	}
+}
+
+// isRelevant returns true if a change to cm may change the generated config. Configmaps that
+// were part of the config at the last list or event are remembered, so removing the type
+// annotation from one is noticed. Changes to the status annotations we write and writes of the
+// config we generated are ignored, so they do not trigger another run.
+func (c *controller) isRelevant(eventType string, cm *ConfigMap) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cm.Metadata.Namespace == c.targetNamespace && cm.Metadata.Name == c.targetName {
+		return eventType == eventDeleted || hashConfigMap(cm) != c.writtenHash
+	}
+
+	key := configMapKey(cm)
+	last, known := c.sources[key]
+	if eventType == eventDeleted || cm.Metadata.Annotations[typeAnnotationKey] == "" {
+		delete(c.sources, key)
+		return known
+	}
+	h := hashSource(cm)
+	c.sources[key] = h
+	return !known || h != last
+}
+
+// rememberSources replaces the set of configmaps that are part of the config with items.
+func (c *controller) rememberSources(items []*ConfigMap) {
+	sources := make(map[string]string, len(items))
+	for _, cm := range items {
+		sources[configMapKey(cm)] = hashSource(cm)
+	}
+	c.mu.Lock()
+	c.sources = sources
+	c.mu.Unlock()
+}
+
+// hashSource hashes everything about a source configmap that can change the generated config,
+// which is all but the status annotations.
+func hashSource(cm *ConfigMap) string {
+	annotations := make(map[string]string, len(cm.Metadata.Annotations))
+	for k, v := range cm.Metadata.Annotations {
+		switch k {
+		case statusAnnotationKey, statusReasonAnnotationKey, statusHashAnnotationKey:
+		default:
+			annotations[k] = v
+		}
+	}
+	return hashData([]interface{}{cm.Data, cm.Metadata.Labels, annotations})
+}
+
+// sleepContext waits for d. It returns false if ctx was canceled first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}